@@ -19,14 +19,42 @@ var tmplContentEventHandler string
 //go:embed tmpl/controller.go.tmpl
 var tmplContentController string
 
+//go:embed tmpl/types.go.tmpl
+var tmplContentTypes string
+
+//go:embed tmpl/register.go.tmpl
+var tmplContentRegister string
+
+//go:embed tmpl/validate.go.tmpl
+var tmplContentValidate string
+
+//go:embed tmpl/conversion.go.tmpl
+var tmplContentConversion string
+
+//go:embed tmpl/conversion_webhook.go.tmpl
+var tmplContentWebhook string
+
+//go:embed tmpl/clients.go.tmpl
+var tmplContentClients string
+
+//go:embed tmpl/metadata_informer.go.tmpl
+var tmplContentMetadataInformer string
+
 //go:embed tmpl/deepcopy.go.tmpl
 var tmplContentDeepCopy string
 
 var (
-	tmplBase         = template.New("base").Funcs(sprig.FuncMap())
-	tmplGoMod        = template.Must(tmplBase.New("gomod").Parse(tmplContentGoMod))
-	tmplMain         = template.Must(tmplBase.New("main").Parse(tmplContentMain))
-	tmplEventHandler = template.Must(tmplBase.New("event_handler").Parse(tmplContentEventHandler))
-	tmplController   = template.Must(tmplBase.New("controller").Parse(tmplContentController))
-	tmplDeepCopy     = template.Must(tmplBase.New("deepcopy").Parse(tmplContentDeepCopy))
+	tmplBase             = template.New("base").Funcs(sprig.FuncMap())
+	tmplGoMod            = template.Must(tmplBase.New("gomod").Parse(tmplContentGoMod))
+	tmplMain             = template.Must(tmplBase.New("main").Parse(tmplContentMain))
+	tmplEventHandler     = template.Must(tmplBase.New("event_handler").Parse(tmplContentEventHandler))
+	tmplController       = template.Must(tmplBase.New("controller").Parse(tmplContentController))
+	tmplTypes            = template.Must(tmplBase.New("types").Parse(tmplContentTypes))
+	tmplRegister         = template.Must(tmplBase.New("register").Parse(tmplContentRegister))
+	tmplValidate         = template.Must(tmplBase.New("validate").Parse(tmplContentValidate))
+	tmplConversion       = template.Must(tmplBase.New("conversion").Parse(tmplContentConversion))
+	tmplWebhook          = template.Must(tmplBase.New("conversion_webhook").Parse(tmplContentWebhook))
+	tmplClients          = template.Must(tmplBase.New("clients").Parse(tmplContentClients))
+	tmplMetadataInformer = template.Must(tmplBase.New("metadata_informer").Parse(tmplContentMetadataInformer))
+	tmplDeepCopy         = template.Must(tmplBase.New("deepcopy").Parse(tmplContentDeepCopy))
 )
@@ -0,0 +1,67 @@
+package generator
+
+import (
+	"path/filepath"
+	"text/template"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const clientsFileName = "clients.go"
+
+// initClients adds the write clients to the controller:
+// a kubernetes.Interface for builtin resources, and a typed REST client for each custom resource.
+func (c *Controller) initClients() {
+	hasBuiltin := false
+	imports := sets.New[string]()
+	for i := range c.Resources {
+		r := &(c.Resources[i])
+		if !r.IsCustom {
+			hasBuiltin = true
+			continue
+		}
+		if r.GoType != r.Kind {
+			alias := getAlias(r.Package)
+			imports.Insert(alias + ` "` + r.Package + `"`)
+		}
+		c.ClientFields = append(c.ClientFields, r.LowerKind+"Client *"+r.Kind+"Client")
+		c.NewControllerArgs = append(c.NewControllerArgs, r.LowerKind+"Client *"+r.Kind+"Client,")
+		c.NewControllerCallArgs = append(c.NewControllerCallArgs, "New"+r.Kind+"Client("+r.LowerKind+"Client)")
+		c.StructFieldInits = append(c.StructFieldInits, "c."+r.LowerKind+"Client = "+r.LowerKind+"Client")
+		if r.Subresources.Status {
+			c.ControllerImports = append(c.ControllerImports, `metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`)
+		}
+	}
+	if hasBuiltin {
+		c.ClientFields = append(c.ClientFields, "kubeClient kubernetes.Interface")
+		c.NewControllerArgs = append(c.NewControllerArgs, "kubeClient kubernetes.Interface,")
+		c.NewControllerCallArgs = append(c.NewControllerCallArgs, "kubeClient")
+		c.StructFieldInits = append(c.StructFieldInits, "c.kubeClient = kubeClient")
+		c.InformerInits = append([]string{"kubeClient := mustGetOrLogFatal(kubernetes.NewForConfigAndClient(config, httpClient))"}, c.InformerInits...)
+		c.ControllerImports = append(c.ControllerImports, `"k8s.io/client-go/kubernetes"`)
+		c.MainImports = append(c.MainImports, `"k8s.io/client-go/kubernetes"`)
+	}
+	c.ControllerImports = sets.List(sets.New(c.ControllerImports...))
+	c.ClientImports = sets.List(imports)
+}
+
+// CreateOrRewriteClients generates the typed REST clients of custom resources.
+// The file is removed if there is no custom resource.
+func CreateOrRewriteClients(tx *Transaction, tmpl *template.Template, config *Controller) error {
+	fp := filepath.Join(config.Base, clientsFileName)
+	if !config.HasCustomResources {
+		if isGeneratedByKoolbuilder(fp) {
+			log.Info("no custom resource; remove file", "file", fp)
+			tx.Remove(fp)
+		}
+		return nil
+	}
+	log.Info("create or rewrite file", "file", clientsFileName)
+	b, err := renderGo(tmpl, config)
+	if err != nil {
+		return err
+	}
+	tx.Add(fp, b)
+	return nil
+}
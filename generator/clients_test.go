@@ -0,0 +1,136 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+// clientsConfig controls a namespaced custom resource with the status subresource, and pods.
+const clientsConfig = `name: Foo
+go:
+  module: example.com/generated
+resources:
+- kind: Foo
+  group: example.com
+  version: v1
+  package: example.com/generated/api/v1
+  template: 3
+  isCustom: true
+  isNamespaced: true
+  subresources:
+    status: true
+- kind: Pod
+  isNamespaced: true
+`
+
+// clientsTest sends requests with the typed client of Foo to a fake API server.
+const clientsTest = `package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+
+	v1 "example.com/generated/api/v1"
+)
+
+func TestFooClient(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, ` + "`" + `{"apiVersion":"example.com/v1","kind":"Foo","metadata":{"name":"a","namespace":"ns"}}` + "`" + `)
+	}))
+	defer server.Close()
+
+	registerTypes(scheme.Scheme)
+	client, err := rest.RESTClientFor(&rest.Config{
+		Host:    server.URL,
+		APIPath: "/apis",
+		ContentConfig: rest.ContentConfig{
+			GroupVersion:         &schema.GroupVersion{Group: "example.com", Version: "v1"},
+			NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewFooClient(client)
+	ctx := context.Background()
+	foo := &v1.Foo{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"}}
+
+	got, err := c.Get(ctx, "ns", "a", metav1.GetOptions{})
+	if err != nil || got.Name != "a" {
+		t.Fatalf("Get() = %v, %v", got, err)
+	}
+	if _, err = c.Create(ctx, foo, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Update(ctx, foo, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.UpdateStatus(ctx, foo, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Patch(ctx, "ns", "a", types.MergePatchType, []byte(` + "`" + `{"spec":{}}` + "`" + `), metav1.PatchOptions{}, "status"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Delete(ctx, "ns", "a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// the status helper of the controller uses the client
+	if _, err = (&Foo{fooClient: c}).updateFooStatus(ctx, foo); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{
+		"GET /apis/example.com/v1/namespaces/ns/foos/a ",
+		"POST /apis/example.com/v1/namespaces/ns/foos ",
+		"PUT /apis/example.com/v1/namespaces/ns/foos/a ",
+		"PUT /apis/example.com/v1/namespaces/ns/foos/a/status ",
+		"PATCH /apis/example.com/v1/namespaces/ns/foos/a/status {\"spec\":{}}",
+		"DELETE /apis/example.com/v1/namespaces/ns/foos/a ",
+		"PUT /apis/example.com/v1/namespaces/ns/foos/a/status ",
+	} {
+		if i >= len(requests) || !strings.HasPrefix(requests[i], want) {
+			t.Errorf("requests = %q, want request %d with prefix %q", requests, i, want)
+		}
+	}
+}
+`
+
+func TestGeneratedClients(t *testing.T) {
+	dir := generateController(t, clientsConfig, map[string]string{"clients_test.go": clientsTest})
+	runGoTest(t, dir)
+}
+
+func TestInitClients(t *testing.T) {
+	c := &Controller{Resources: []Resource{
+		{Kind: "Foo", LowerKind: "foo", GoType: "apiv1.Foo", Package: "example.com/foo/api/v1", IsCustom: true, Subresources: Subresources{Status: true}},
+		{Kind: "Pod", LowerKind: "pod", GoType: "corev1.Pod", Package: "k8s.io/api/core/v1"},
+	}}
+	c.initClients()
+	for _, want := range []string{"fooClient *FooClient", "kubeClient kubernetes.Interface"} {
+		if !hasLine(c.ClientFields, want) {
+			t.Errorf("missing client field %q in %q", want, c.ClientFields)
+		}
+	}
+	if want := "NewFooClient(fooClient), kubeClient"; strings.Join(c.NewControllerCallArgs, ", ") != want {
+		t.Errorf("call args = %q, want %q", c.NewControllerCallArgs, want)
+	}
+	if want := []string{`apiv1 "example.com/foo/api/v1"`}; len(c.ClientImports) != 1 || c.ClientImports[0] != want[0] {
+		t.Errorf("client imports = %q, want %q", c.ClientImports, want)
+	}
+	if !hasLine(c.ControllerImports, `metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`) {
+		t.Errorf("controller imports = %q, want metav1 for the status helper", c.ControllerImports)
+	}
+}
@@ -4,11 +4,9 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 
 	"github.com/FlyingOnion/pkg/log"
-	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 type Controller struct {
@@ -22,7 +20,41 @@ type Controller struct {
 	Namespace string     `yaml:"namespace"`
 	Resources []Resource `yaml:"resources"`
 
+	// Workers is the number of workers that sync the main resource concurrently. By default it is 1.
+	Workers int `yaml:"workers"`
+	// RateLimiter decides how long a failed key waits before it is requeued.
+	// By default it is the same as workqueue.DefaultControllerRateLimiter.
+	RateLimiter RateLimiter `yaml:"rateLimiter"`
+
+	// ResyncPeriod is the resync period of informers, e.g. "30s", "5m" or "0" to disable resync.
+	// By default it is 30s. A resource can override it with its own resyncPeriod.
+	ResyncPeriod string `yaml:"resyncPeriod"`
+
+	// PostGenerate is the list of steps to run after generation.
+	// By default it runs "go mod tidy" only.
+	PostGenerate []Step `yaml:"postGenerate"`
+
+	History HistoryConfig `yaml:"history"`
+
+	// ConversionWebhook serves the conversion of multi-version custom resources.
+	ConversionWebhook ConversionWebhook `yaml:"conversionWebhook"`
+
 	HasCustomResources bool `yaml:"-"`
+	// HasSelectors is true if any informer lists and watches with selectors.
+	HasSelectors bool `yaml:"-"`
+	// HasMetadataInformers is true if any resource is cached as metadata only.
+	HasMetadataInformers bool `yaml:"-"`
+	// HasStripManagedFields is true if any informer strips managed fields.
+	HasStripManagedFields bool `yaml:"-"`
+	// HasEnqueueOwner is true if any secondary resource enqueues its owner.
+	HasEnqueueOwner bool `yaml:"-"`
+	// HasMapToPrimary is true if any secondary resource has mapping rules to the main resource.
+	HasMapToPrimary bool `yaml:"-"`
+	// PrimaryIndexers are the indexes on the main resource of field path mapping rules.
+	PrimaryIndexers []PrimaryIndexer `yaml:"-"`
+	// LegacyDoSync is true if doSync in the existing event_handler.go returns an error only,
+	// instead of (Result, error).
+	LegacyDoSync bool `yaml:"-"`
 
 	// template: controller
 	//  type Controller struct {
@@ -47,6 +79,11 @@ type Controller struct {
 	//  xxxInformer := kool.NewNamespacedInformer // namespaced
 	InformerInits []string `yaml:"-"`
 
+	// template: main
+	//  var resyncPeriod time.Duration
+	//  pflag.CommandLine.DurationVar(&resyncPeriod, "resync-period", 30*time.Second, "...")
+	MainFlags []string `yaml:"-"`
+
 	// template: main
 	//  go c.xxxInformer.Informer().Run(ctx.Done())
 	InformerRuns []string `yaml:"-"`
@@ -58,7 +95,36 @@ type Controller struct {
 	//  )
 	NewControllerArgs []string `yaml:"-"`
 
+	// template: main
+	//  controller := NewController(xxxInformer, NewXxxClient(xxxClient), kubeClient, queue, retry)
+	NewControllerCallArgs []string `yaml:"-"`
+
+	// template: controller
+	//  type Controller struct {
+	//      xxxClient *XxxClient           // custom
+	//      kubeClient kubernetes.Interface // builtin
+	//  }
+	ClientFields []string `yaml:"-"`
+
+	// template: main
+	//  utilruntime.Must(xxx.AddToScheme(s))                 // generated template
+	//  s.AddKnownTypes(schema.GroupVersion{...}, &xxx.Kind{}) // template none
+	SchemeRegistrations []string `yaml:"-"`
+
 	Imports []string `yaml:"-"`
+	// MainImports are the imports used by main.go only
+	MainImports []string `yaml:"-"`
+	// ControllerImports are the imports used by controller.go only
+	ControllerImports []string `yaml:"-"`
+	// ClientImports are the imports used by clients.go only
+	ClientImports []string `yaml:"-"`
+	// EventHandlerImports are the imports used by event_handler.go only
+	EventHandlerImports []string `yaml:"-"`
+
+	// source is the raw configuration, saved in snapshots
+	source []byte
+	// packages are the loaded packages of custom resources, by directory
+	packages map[string]*loadedPackage
 }
 
 type GoConfig struct {
@@ -85,12 +151,67 @@ type Resource struct {
 	Package string
 	// Alias   string
 
+	// Plural is the plural name of the resource, used in the CRD.
+	// By default it is derived from the lowercase kind, e.g. "foos", "policies".
+	Plural string
+
+	// Subresources and PrinterColumns of a custom resource are written to its CRD.
+	Subresources   Subresources    `yaml:"subresources"`
+	PrinterColumns []PrinterColumn `yaml:"printerColumns"`
+
+	// Versions are the versions of a multi-version custom resource.
+	// Version is the one watched by the informer.
+	Versions []ResourceVersion `yaml:"versions"`
+
+	// ResyncPeriod overrides the resync period of the controller for the informer of this resource.
+	ResyncPeriod string `yaml:"resyncPeriod"`
+	// LabelSelector and FieldSelector restrict the objects listed and watched by the informer,
+	// e.g. "app.kubernetes.io/managed-by=us" and "type=kubernetes.io/tls".
+	LabelSelector string `yaml:"labelSelector"`
+	FieldSelector string `yaml:"fieldSelector"`
+	// Cache is "full" by default. A secondary resource can be "metadataOnly"
+	// to cache *metav1.PartialObjectMetadata instead of whole objects.
+	Cache CacheMode `yaml:"cache"`
+	// StripManagedFields drops metadata.managedFields of objects before they are cached.
+	StripManagedFields bool `yaml:"stripManagedFields"`
+	// EnqueueOwner enqueues the controller owner of a secondary resource
+	// if the owner is the main resource, e.g. the Deployment of a ReplicaSet.
+	EnqueueOwner bool `yaml:"enqueueOwner"`
+	// MapToPrimary are the rules to map a secondary object to the main resources to enqueue,
+	// for relationships other than ownerReferences.
+	MapToPrimary []PrimaryMapping `yaml:"mapToPrimary"`
+
 	Template     Template
 	IsCustom     bool `yaml:"isCustom"`
 	IsNamespaced bool `yaml:"isNamespaced"`
 
 	LowerKind string `yaml:"-"`
 	GoType    string `yaml:"-"`
+	// CachedType is the type of objects in the cache and in event handlers,
+	// which is GoType or metav1.PartialObjectMetadata.
+	CachedType string `yaml:"-"`
+
+	// Dir and GoPackage are the directory and the go package name
+	// of a custom resource whose template is generated.
+	Dir       string `yaml:"-"`
+	GoPackage string `yaml:"-"`
+	// HasValidate is true if the resource has a generated Validate method.
+	HasValidate bool `yaml:"-"`
+
+	// definitionCreated is true if the definition file is created in this run
+	definitionCreated bool
+	// versions are the resources of each version, including the resource itself
+	versions []*Resource
+	// served, storage and hub are the flags of this version
+	served, storage, hub bool
+	// resyncVar is the variable of the resync period in main.go
+	resyncVar string
+	// informerClient is the client expression the informer is created with in main.go
+	informerClient string
+	// labelSelector and fieldSelector are the parsed selectors
+	labelSelector, fieldSelector string
+	// indexPaths are the field paths of the main resource indexed by mapping rules
+	indexPaths []string
 }
 
 const (
@@ -124,11 +245,47 @@ func defaultController() *Controller {
 			Version:       defaultGoVersion,
 			K8sAPIVersion: defaultK8sAPIVersion,
 		},
-		Retry: 3,
+		Retry:   3,
+		Workers: 1,
+		History: HistoryConfig{
+			Retain: defaultHistoryRetain,
+		},
 	}
 }
 
 func (c *Controller) InitAndValidate() error {
+	if err := c.initBase(); err != nil {
+		return err
+	}
+	c.initDefaults()
+	if err := c.initRetry(); err != nil {
+		return err
+	}
+	if err := c.initWorkers(); err != nil {
+		return err
+	}
+	if err := c.initRateLimiter(); err != nil {
+		return err
+	}
+	if err := c.initHistory(); err != nil {
+		return err
+	}
+	if err := c.initPostGenerate(); err != nil {
+		return err
+	}
+	if err := c.initResources(); err != nil {
+		return err
+	}
+	c.initClients()
+	if err := c.initConversionWebhook(); err != nil {
+		return err
+	}
+	c.initSyncResult()
+	return c.initSchemeRegistration()
+}
+
+// initBase cleans the base directory, and creates it if needed.
+func (c *Controller) initBase() error {
 	if len(c.Base) == 0 {
 		c.Base = "."
 	}
@@ -142,6 +299,11 @@ func (c *Controller) InitAndValidate() error {
 			return err
 		}
 	}
+	return nil
+}
+
+// initDefaults sets the name and the go config that are not set.
+func (c *Controller) initDefaults() {
 	if len(c.Name) == 0 {
 		c.Name = defaultName
 	}
@@ -154,77 +316,13 @@ func (c *Controller) InitAndValidate() error {
 	if len(c.Go.K8sAPIVersion) == 0 {
 		c.Go.K8sAPIVersion = defaultK8sAPIVersion
 	}
+}
+
+func (c *Controller) initRetry() error {
 	if c.Retry < 0 || c.Retry > 10 {
 		log.Error(msgConfigInvalid, "cause", msgInvalidRetry)
 		return errors.New(msgConfigInvalid)
 	}
-	// initializations below uses len(c.Resources)
-	// so we need to ensure that it is not 0
-	if len(c.Resources) == 0 {
-		log.Error(msgConfigInvalid, "cause", msgNoResources)
-		return errors.New(msgConfigInvalid)
-	}
-
-	// imports is used to deal with extra imports
-	// it collects all unique imports and generates Controller.Imports
-	imports := sets.Set[string]{}
-
-	c.ListerFields = make([]string, 0, len(c.Resources))
-	c.HasSyncedFields = make([]string, 0, len(c.Resources))
-	c.StructFieldInits = make([]string, 0, 2*len(c.Resources))
-	c.InformerInits = make([]string, 0, 2*len(c.Resources))
-	c.InformerRuns = make([]string, 0, len(c.Resources))
-	c.NewControllerArgs = make([]string, 0, len(c.Resources))
-
-	clientInits := make([]string, 0, len(c.Resources))
-	informerInits := make([]string, 0, len(c.Resources))
-	for i := range c.Resources {
-		if len(c.Resources[i].Kind) == 0 || c.Resources[i].Kind == "UnknownType" {
-			log.Error(msgConfigInvalid, "cause", msgUnknownResourceKind)
-			return errors.New(msgUnknownResourceKind)
-		}
-		// field initializations
-		c.Resources[i].LowerKind = strings.ToLower(c.Resources[i].Kind)
-		c.HasCustomResources = c.HasCustomResources || c.Resources[i].IsCustom
-		if c.Resources[i].IsCustom {
-			initGVPLocalAndThirdParty(&(c.Resources[i]))
-		} else {
-			initGVPBuiltin(&(c.Resources[i]))
-		}
-
-		// init go type and add import
-		if len(c.Resources[i].Group) > 0 && (len(c.Resources[i].Package) == 0 || c.Resources[i].Package == c.Go.Module) {
-			c.Resources[i].GoType = c.Resources[i].Kind
-		} else {
-			alias := getAlias(c.Resources[i].Package)
-			c.Resources[i].GoType = alias + "." + c.Resources[i].Kind
-			imports.Insert(alias + ` "` + c.Resources[i].Package + `"`)
-		}
-		// init ns-based fields
-		if len(c.Namespace) > 0 && c.Resources[i].IsNamespaced {
-			c.ListerFields = append(c.ListerFields, c.Resources[i].LowerKind+"Lister kool.NamespacedLister["+c.Resources[i].GoType+"]")
-			clientInits = append(clientInits, c.Resources[i].LowerKind+`Client := mustGetOrLogFatal(kool.NewRESTClient(config, httpClient, &schema.GroupVersion{Group: "`+c.Resources[i].SchemaGroup+`", Version: "`+c.Resources[i].Version+`"}))`)
-			informerInits = append(informerInits, c.Resources[i].LowerKind+`Informer := kool.NewNamespacedInformer[`+c.Resources[i].GoType+`](`+c.Resources[i].LowerKind+`Client, "`+c.Namespace+`", 30*time.Second)`)
-			c.NewControllerArgs = append(c.NewControllerArgs, c.Resources[i].LowerKind+`Informer kool.NamespacedInformer[`+c.Resources[i].GoType+`],`)
-		} else {
-			c.ListerFields = append(c.ListerFields, c.Resources[i].LowerKind+"Lister kool.Lister["+c.Resources[i].GoType+"]")
-			clientInits = append(clientInits, c.Resources[i].LowerKind+`Client := mustGetOrLogFatal(kool.NewRESTClient(config, httpClient, &schema.GroupVersion{Group: "`+c.Resources[i].SchemaGroup+`", Version: "`+c.Resources[i].Version+`"}))`)
-			informerInits = append(informerInits, c.Resources[i].LowerKind+`Informer := kool.NewInformer[`+c.Resources[i].GoType+`](`+c.Resources[i].LowerKind+`Client, 30*time.Second)`)
-			c.NewControllerArgs = append(c.NewControllerArgs, c.Resources[i].LowerKind+`Informer kool.Informer[`+c.Resources[i].GoType+`],`)
-		}
-		// init ns-independent fields
-		c.HasSyncedFields = append(c.HasSyncedFields, c.Resources[i].LowerKind+"Synced cache.InformerSynced")
-		c.StructFieldInits = append(c.StructFieldInits,
-			"c."+c.Resources[i].LowerKind+"Lister = "+c.Resources[i].LowerKind+"Informer.Lister()",
-			"c."+c.Resources[i].LowerKind+"Synced = "+c.Resources[i].LowerKind+"Informer.Informer().HasSynced",
-		)
-		c.InformerRuns = append(c.InformerRuns, "go "+c.Resources[i].LowerKind+"Informer.Informer().Run(ctx.Done())")
-	}
-	c.InformerInits = append(c.InformerInits, clientInits...)
-	c.InformerInits = append(c.InformerInits, informerInits...)
-	importList := imports.UnsortedList()
-	sort.Strings(importList)
-	c.Imports = importList
 	return nil
 }
 
@@ -0,0 +1,75 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/FlyingOnion/pkg/log"
+)
+
+// ConversionFile is the data of template conversion.
+type ConversionFile struct {
+	Package string
+	Kind    string
+	Version string
+
+	IsHub      bool
+	HubVersion string
+	// HubImport and HubType are the import and the go type of the hub version in other versions
+	HubImport string
+	HubType   string
+}
+
+// conversionFileName returns the file name of the conversion functions of r.
+func conversionFileName(r *Resource) string {
+	return r.LowerKind + "_conversion.go"
+}
+
+// CreateConversion generates the conversion scaffolding of multi-version custom resources
+// into <kind>_conversion.go of each version package.
+//
+// The hub version gets a Hub method, and other versions get ConvertTo and ConvertFrom against the hub.
+// The file belongs to the user once created, and is never rewritten.
+func CreateConversion(tx *Transaction, tmpl *template.Template, config *Controller) error {
+	for i := range config.Resources {
+		r := &(config.Resources[i])
+		if !r.IsCustom || r.Template == TemplateNone || len(r.versions) < 2 {
+			continue
+		}
+		hub := r.hubVersion()
+		for _, v := range r.versions {
+			fp := filepath.Join(v.Dir, conversionFileName(v))
+			if _, err := os.Stat(fp); err == nil {
+				continue
+			}
+			declared, err := declaredInDir(v.Dir, fp)
+			if err != nil {
+				return err
+			}
+			if declared.HasAny(v.Kind+".Hub", v.Kind+".ConvertTo", v.Kind+".ConvertFrom") {
+				log.Info("conversion already exists in package; skip", "resource", v.Kind, "version", v.Version, "directory", v.Dir)
+				continue
+			}
+			file := &ConversionFile{
+				Package:    v.GoPackage,
+				Kind:       v.Kind,
+				Version:    v.Version,
+				IsHub:      v == hub,
+				HubVersion: hub.Version,
+			}
+			if !file.IsHub {
+				alias := getAlias(hub.Package)
+				file.HubImport = alias + ` "` + hub.Package + `"`
+				file.HubType = alias + "." + hub.Kind
+			}
+			log.Info("create conversion", "resource", v.Kind, "version", v.Version, "hub", hub.Version, "file", fp)
+			b, err := renderGo(tmpl, file)
+			if err != nil {
+				return err
+			}
+			tx.Add(fp, b)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	msgUnsupportedSchemaType = `type cannot be expressed by an OpenAPI v3 schema`
+	msgFailedToGenCRD        = `failed to generate CustomResourceDefinition`
+	msgEmptyCustomGroup      = `custom resource must have a group`
+	msgEmptyCustomGroupTip   = `set group to a domain you own, e.g. example.com; the empty group is reserved for k8s core resources`
+)
+
+const (
+	crdDir    = "config/crd"
+	crdHeader = "# Code generated by koolbuilder. DO NOT EDIT.\n"
+
+	descAPIVersion = "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources"
+	descKind       = "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds"
+
+	quantityPattern = `^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+)
+
+// CustomResourceDefinition is an apiextensions.k8s.io/v1 CustomResourceDefinition.
+// Only the fields generated by koolbuilder are declared.
+type CustomResourceDefinition struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   CRDMetadata `yaml:"metadata"`
+	Spec       CRDSpec     `yaml:"spec"`
+}
+
+type CRDMetadata struct {
+	Name string `yaml:"name"`
+}
+
+type CRDSpec struct {
+	Group      string         `yaml:"group"`
+	Names      CRDNames       `yaml:"names"`
+	Scope      string         `yaml:"scope"`
+	Versions   []CRDVersion   `yaml:"versions"`
+	Conversion *CRDConversion `yaml:"conversion,omitempty"`
+}
+
+type CRDNames struct {
+	Kind     string `yaml:"kind"`
+	ListKind string `yaml:"listKind"`
+	Plural   string `yaml:"plural"`
+	Singular string `yaml:"singular"`
+}
+
+type CRDVersion struct {
+	Name                     string           `yaml:"name"`
+	Served                   bool             `yaml:"served"`
+	Storage                  bool             `yaml:"storage"`
+	Schema                   CRDValidation    `yaml:"schema"`
+	Subresources             *CRDSubresources `yaml:"subresources,omitempty"`
+	AdditionalPrinterColumns []PrinterColumn  `yaml:"additionalPrinterColumns,omitempty"`
+}
+
+type CRDConversion struct {
+	Strategy string                `yaml:"strategy"`
+	Webhook  *CRDWebhookConversion `yaml:"webhook,omitempty"`
+}
+
+type CRDWebhookConversion struct {
+	ClientConfig             CRDWebhookClientConfig `yaml:"clientConfig"`
+	ConversionReviewVersions []string               `yaml:"conversionReviewVersions"`
+}
+
+type CRDWebhookClientConfig struct {
+	Service CRDServiceReference `yaml:"service"`
+}
+
+type CRDServiceReference struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	Path      string `yaml:"path"`
+	Port      int    `yaml:"port"`
+}
+
+type CRDValidation struct {
+	OpenAPIV3Schema *JSONSchema `yaml:"openAPIV3Schema"`
+}
+
+// JSONSchema is a structural OpenAPI v3 schema.
+type JSONSchema struct {
+	Description          string                 `yaml:"description,omitempty"`
+	Type                 string                 `yaml:"type,omitempty"`
+	Format               string                 `yaml:"format,omitempty"`
+	Pattern              string                 `yaml:"pattern,omitempty"`
+	Enum                 []any                  `yaml:"enum,omitempty"`
+	Minimum              *float64               `yaml:"minimum,omitempty"`
+	Maximum              *float64               `yaml:"maximum,omitempty"`
+	MaxItems             *int64                 `yaml:"maxItems,omitempty"`
+	Default              any                    `yaml:"default,omitempty"`
+	AnyOf                []*JSONSchema          `yaml:"anyOf,omitempty"`
+	Items                *JSONSchema            `yaml:"items,omitempty"`
+	Properties           map[string]*JSONSchema `yaml:"properties,omitempty"`
+	AdditionalProperties *JSONSchema            `yaml:"additionalProperties,omitempty"`
+	Required             []string               `yaml:"required,omitempty"`
+
+	XIntOrString           bool `yaml:"x-kubernetes-int-or-string,omitempty"`
+	XPreserveUnknownFields bool `yaml:"x-kubernetes-preserve-unknown-fields,omitempty"`
+}
+
+// wellKnownSchemas are the schemas of types that are not serialized as their go structure.
+var wellKnownSchemas = map[string]func() *JSONSchema{
+	"k8s.io/apimachinery/pkg/apis/meta/v1.Time":       func() *JSONSchema { return &JSONSchema{Type: "string", Format: "date-time"} },
+	"k8s.io/apimachinery/pkg/apis/meta/v1.MicroTime":  func() *JSONSchema { return &JSONSchema{Type: "string", Format: "date-time"} },
+	"k8s.io/apimachinery/pkg/apis/meta/v1.Duration":   func() *JSONSchema { return &JSONSchema{Type: "string"} },
+	"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta": func() *JSONSchema { return &JSONSchema{Type: "object"} },
+	"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta":   func() *JSONSchema { return &JSONSchema{Type: "object"} },
+	"k8s.io/apimachinery/pkg/types.UID":               func() *JSONSchema { return &JSONSchema{Type: "string"} },
+	"k8s.io/apimachinery/pkg/api/resource.Quantity": func() *JSONSchema {
+		return &JSONSchema{
+			AnyOf:        []*JSONSchema{{Type: "integer"}, {Type: "string"}},
+			Pattern:      quantityPattern,
+			XIntOrString: true,
+		}
+	},
+	"k8s.io/apimachinery/pkg/util/intstr.IntOrString": func() *JSONSchema {
+		return &JSONSchema{AnyOf: []*JSONSchema{{Type: "integer"}, {Type: "string"}}, XIntOrString: true}
+	},
+	"k8s.io/apimachinery/pkg/runtime.RawExtension": func() *JSONSchema {
+		return &JSONSchema{Type: "object", XPreserveUnknownFields: true}
+	},
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1.JSON": func() *JSONSchema {
+		return &JSONSchema{XPreserveUnknownFields: true}
+	},
+}
+
+// schemaGen generates the OpenAPI v3 schema of go types, the way controller-gen does.
+type schemaGen struct {
+	pkg *loadedPackage
+	// visiting are the named types being generated, to detect recursive types,
+	// which cannot be expressed by a structural schema
+	visiting sets.Set[*types.Named]
+}
+
+func newSchemaGen(pkg *loadedPackage) *schemaGen {
+	return &schemaGen{pkg: pkg, visiting: sets.New[*types.Named]()}
+}
+
+func (g *schemaGen) schema(t types.Type) (*JSONSchema, error) {
+	if named, ok := t.(*types.Named); ok {
+		if p := named.Obj().Pkg(); p != nil {
+			if fn, ok := wellKnownSchemas[p.Path()+"."+named.Obj().Name()]; ok {
+				return fn(), nil
+			}
+			if types.TypeString(t, nil) == runtimeObject {
+				return &JSONSchema{Type: "object", XPreserveUnknownFields: true}, nil
+			}
+			if g.pkg.stubs.Has(p.Path()) {
+				// the fields of stubbed types are unknown
+				return &JSONSchema{Type: "object", XPreserveUnknownFields: true}, nil
+			}
+		}
+		if g.visiting.Has(named) {
+			log.Warn("recursive type cannot be expressed by a structural schema; unknown fields are preserved", "type", types.TypeString(t, nil))
+			return &JSONSchema{Type: "object", XPreserveUnknownFields: true}, nil
+		}
+		g.visiting.Insert(named)
+		defer g.visiting.Delete(named)
+
+		s, err := g.underlyingSchema(t)
+		if err != nil {
+			return nil, err
+		}
+		// markers of local types, e.g. an enum of a string type
+		m, err := g.pkg.markersOf(named.Obj())
+		if err != nil {
+			return nil, err
+		}
+		m.apply(s)
+		return s, nil
+	}
+	return g.underlyingSchema(t)
+}
+
+func (g *schemaGen) underlyingSchema(t types.Type) (*JSONSchema, error) {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return basicSchema(u)
+	case *types.Pointer:
+		return g.schema(u.Elem())
+	case *types.Slice:
+		return g.arraySchema(u.Elem())
+	case *types.Array:
+		return g.arraySchema(u.Elem())
+	case *types.Map:
+		elem, err := g.schema(u.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &JSONSchema{Type: "object", AdditionalProperties: elem}, nil
+	case *types.Struct:
+		return g.structSchema(u)
+	case *types.Interface:
+		return &JSONSchema{XPreserveUnknownFields: true}, nil
+	}
+	return nil, fmt.Errorf("%s: %s", msgUnsupportedSchemaType, types.TypeString(t, nil))
+}
+
+func basicSchema(b *types.Basic) (*JSONSchema, error) {
+	switch b.Kind() {
+	case types.String:
+		return &JSONSchema{Type: "string"}, nil
+	case types.Bool:
+		return &JSONSchema{Type: "boolean"}, nil
+	case types.Int, types.Int8, types.Int16, types.Uint, types.Uint8, types.Uint16:
+		return &JSONSchema{Type: "integer"}, nil
+	case types.Int32, types.Uint32:
+		return &JSONSchema{Type: "integer", Format: "int32"}, nil
+	case types.Int64, types.Uint64:
+		return &JSONSchema{Type: "integer", Format: "int64"}, nil
+	case types.Float32, types.Float64:
+		return &JSONSchema{Type: "number"}, nil
+	}
+	return nil, fmt.Errorf("%s: %s", msgUnsupportedSchemaType, b.Name())
+}
+
+func (g *schemaGen) arraySchema(elem types.Type) (*JSONSchema, error) {
+	if b, ok := elem.(*types.Basic); ok && b.Kind() == types.Byte {
+		// []byte is encoded as a base64 string
+		return &JSONSchema{Type: "string", Format: "byte"}, nil
+	}
+	items, err := g.schema(elem)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONSchema{Type: "array", Items: items}, nil
+}
+
+func (g *schemaGen) structSchema(st *types.Struct) (*JSONSchema, error) {
+	s := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		name, opts, _ := strings.Cut(reflect.StructTag(st.Tag(i)).Get("json"), ",")
+		if name == "-" && len(opts) == 0 || !f.Exported() && !f.Embedded() {
+			continue
+		}
+		inline := f.Embedded() && len(name) == 0 || strings.Contains(","+opts+",", ",inline,")
+		if inline {
+			if err := g.inline(s, f.Type()); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name(), err)
+			}
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name()
+		}
+		prop, err := g.schema(f.Type())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		prop.Description = description(g.pkg.doc(f))
+		m, err := g.pkg.markersOf(f)
+		if err != nil {
+			return nil, err
+		}
+		m.apply(prop)
+		s.Properties[name] = prop
+		// like controller-gen, fields without omitempty are required unless marked otherwise
+		if m.Required || !m.Optional && !m.HasDefault && !strings.Contains(","+opts+",", ",omitempty,") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s, nil
+}
+
+// inline adds the properties of an embedded struct to s.
+func (g *schemaGen) inline(s *JSONSchema, t types.Type) error {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if types.TypeString(t, nil) == "k8s.io/apimachinery/pkg/apis/meta/v1.TypeMeta" {
+		s.Properties["apiVersion"] = &JSONSchema{Description: descAPIVersion, Type: "string"}
+		s.Properties["kind"] = &JSONSchema{Description: descKind, Type: "string"}
+		return nil
+	}
+	embedded, err := g.schema(t)
+	if err != nil {
+		return err
+	}
+	if embedded.Type != "object" || embedded.XPreserveUnknownFields {
+		return fmt.Errorf("%s: %s", msgUnsupportedSchemaType, types.TypeString(t, nil))
+	}
+	for name, prop := range embedded.Properties {
+		s.Properties[name] = prop
+	}
+	s.Required = append(s.Required, embedded.Required...)
+	return nil
+}
+
+// description returns the text of a doc comment without marker lines like "+optional".
+func description(doc *ast.CommentGroup) string {
+	lines := strings.Split(doc.Text(), NewLine)
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "+") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, NewLine))
+}
+
+// pluralize returns the plural of a lowercase kind.
+//
+//	foo       -> foos
+//	policy    -> policies
+//	ingress   -> ingresses
+//	endpoints -> endpoints
+func pluralize(kind string) string {
+	switch {
+	case kind == "endpoints":
+		return kind
+	case strings.HasSuffix(kind, "s"), strings.HasSuffix(kind, "x"), strings.HasSuffix(kind, "z"),
+		strings.HasSuffix(kind, "ch"), strings.HasSuffix(kind, "sh"):
+		return kind + "es"
+	case strings.HasSuffix(kind, "y") && len(kind) > 1 && !strings.ContainsRune("aeiou", rune(kind[len(kind)-2])):
+		return kind[:len(kind)-1] + "ies"
+	}
+	return kind + "s"
+}
+
+// initCRD checks that a custom resource can be described by a CustomResourceDefinition,
+// whose name is <plural>.<group>.
+func initCRD(r *Resource) error {
+	if len(r.Group) == 0 {
+		log.Error(msgConfigInvalid, "cause", msgEmptyCustomGroup, "resource", r.Kind, "tip", msgEmptyCustomGroupTip)
+		return errors.New(msgConfigInvalid)
+	}
+	return nil
+}
+
+// crdFileName returns the path of the CRD of r relative to config.Base.
+func crdFileName(r *Resource) string {
+	return filepath.Join(crdDir, r.SchemaGroup+"_"+r.Plural+".yaml")
+}
+
+// CreateOrRewriteCRD generates the CustomResourceDefinition of each custom resource whose template is generated,
+// with a structural schema from its go type.
+func CreateOrRewriteCRD(tx *Transaction, config *Controller) error {
+	for i := range config.Resources {
+		r := &(config.Resources[i])
+		if !r.IsCustom || r.Template == TemplateNone {
+			continue
+		}
+		crd, err := newCRD(tx, config, r)
+		if err != nil {
+			log.Error(msgFailedToGenCRD, "resource", r.Kind, "package", r.Package, "cause", err)
+			return errors.New(msgFailedToGenCRD)
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(crdHeader)
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err = enc.Encode(crd); err != nil {
+			return err
+		}
+		fp := filepath.Join(config.Base, crdFileName(r))
+		log.Info("write crd", "resource", r.Kind, "file", fp)
+		tx.Add(fp, buf.Bytes())
+	}
+	return nil
+}
+
+// newCRD returns the CRD of r with a version for each version of r.
+func newCRD(tx *Transaction, config *Controller, r *Resource) (*CustomResourceDefinition, error) {
+	versions := make([]CRDVersion, 0, len(r.versions))
+	for _, v := range r.versions {
+		pkg, err := config.resourcePackage(tx, v.Dir)
+		if err != nil {
+			log.Error("failed to load package", "package", v.Package, "directory", v.Dir, "cause", err)
+			return nil, err
+		}
+		schema, err := rootSchema(pkg, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v.Version, err)
+		}
+		v.checkIndexPaths(schema)
+		versions = append(versions, CRDVersion{
+			Name:    v.Version,
+			Served:  v.served,
+			Storage: v.storage,
+			Schema:  CRDValidation{OpenAPIV3Schema: schema},
+
+			Subresources:             r.crdSubresources(),
+			AdditionalPrinterColumns: r.PrinterColumns,
+		})
+	}
+
+	scope := "Cluster"
+	if r.IsNamespaced {
+		scope = "Namespaced"
+	}
+	return &CustomResourceDefinition{
+		APIVersion: "apiextensions.k8s.io/v1",
+		Kind:       "CustomResourceDefinition",
+		Metadata:   CRDMetadata{Name: r.Plural + "." + r.SchemaGroup},
+		Spec: CRDSpec{
+			Group: r.SchemaGroup,
+			Names: CRDNames{
+				Kind:     r.Kind,
+				ListKind: r.Kind + "List",
+				Plural:   r.Plural,
+				Singular: r.LowerKind,
+			},
+			Scope:      scope,
+			Versions:   versions,
+			Conversion: config.crdConversion(r),
+		},
+	}, nil
+}
+
+// rootSchema returns the schema of the go type of r in pkg.
+func rootSchema(pkg *loadedPackage, r *Resource) (*JSONSchema, error) {
+	obj, ok := pkg.types.Scope().Lookup(r.Kind).(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", msgTypeNotFound, r.Kind)
+	}
+	if _, ok = obj.Type().Underlying().(*types.Struct); !ok {
+		return nil, fmt.Errorf("%s: %s", msgTypeNotStruct, r.Kind)
+	}
+	schema, err := newSchemaGen(pkg).schema(obj.Type())
+	if err != nil {
+		return nil, err
+	}
+	schema.Description = description(pkg.doc(obj))
+	// the root of a CRD schema only needs apiVersion, kind and metadata
+	schema.Required = nil
+	if err = r.checkSubresourcePaths(schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPluralize(t *testing.T) {
+	tests := []struct {
+		kind string
+		want string
+	}{
+		{kind: "foo", want: "foos"},
+		{kind: "status", want: "statuses"},
+		{kind: "box", want: "boxes"},
+		{kind: "batch", want: "batches"},
+		{kind: "mesh", want: "meshes"},
+		{kind: "policy", want: "policies"},
+		{kind: "gateway", want: "gateways"},
+		{kind: "y", want: "ys"},
+		{kind: "endpoints", want: "endpoints"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			if got := pluralize(tt.kind); got != tt.want {
+				t.Errorf("pluralize(%q) = %q, want %q", tt.kind, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitCRD(t *testing.T) {
+	if err := initCRD(&Resource{Kind: "Foo", IsCustom: true, Group: "example.com"}); err != nil {
+		t.Errorf("initCRD() = %v, want nil", err)
+	}
+	if err := initCRD(&Resource{Kind: "Foo", IsCustom: true}); err == nil {
+		t.Error("initCRD() of an empty group = nil, want error")
+	}
+}
+
+func TestNewCRD(t *testing.T) {
+	dir := newGoModule(t, map[string]string{"api/v1/types.go": deepCopyTypes})
+	r := &Resource{Kind: "Foo", LowerKind: "foo", Plural: "foos", SchemaGroup: "example.com", Version: "v1", IsNamespaced: true, Dir: filepath.Join(dir, "api", "v1")}
+	if err := r.initVersions(dir, "example.com/generated"); err != nil {
+		t.Fatal(err)
+	}
+	crd, err := newCRD(NewTransaction(), &Controller{Base: dir}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crd.Metadata.Name != "foos.example.com" || crd.Spec.Scope != "Namespaced" || crd.Spec.Names.ListKind != "FooList" {
+		t.Errorf("unexpected crd: %+v", crd)
+	}
+	root := crd.Spec.Versions[0].Schema.OpenAPIV3Schema
+	if root.Required != nil {
+		t.Errorf("root required = %v, want nil", root.Required)
+	}
+	for _, name := range []string{"apiVersion", "kind", "metadata", "spec"} {
+		if _, ok := root.Properties[name]; !ok {
+			t.Errorf("missing property %q", name)
+		}
+	}
+	spec := root.Properties["spec"]
+	tests := []struct {
+		name string
+		want *JSONSchema
+	}{
+		{name: "Replicas", want: &JSONSchema{Type: "integer", Format: "int32"}},
+		{name: "Labels", want: &JSONSchema{Type: "object", AdditionalProperties: &JSONSchema{Type: "string"}}},
+		{name: "Started", want: &JSONSchema{Type: "string", Format: "date-time"}},
+		{name: "Shallow", want: &JSONSchema{
+			Type:       "object",
+			Properties: map[string]*JSONSchema{"X": {Type: "integer"}, "Y": {Type: "integer"}},
+			Required:   []string{"X", "Y"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := spec.Properties[tt.name]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("schema of %s = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	// Item.Next is recursive and cannot be expressed by a structural schema
+	next := spec.Properties["Items"].Items.Properties["Next"]
+	if !next.XPreserveUnknownFields {
+		t.Errorf("schema of recursive Next = %+v, want unknown fields preserved", next)
+	}
+}
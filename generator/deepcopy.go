@@ -0,0 +1,376 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const runtimeObject = "k8s.io/apimachinery/pkg/runtime.Object"
+
+const (
+	msgTypeNotFound            = `type not found in package`
+	msgTypeNotStruct           = `type is not a struct`
+	msgNoDeepCopyInto          = `type of another package has no DeepCopyInto method`
+	msgUnsupportedDeepCopyType = `type cannot be deep copied`
+	msgFailedToGenDeepCopy     = `failed to generate deepcopy`
+	msgDeepCopyTip             = `define the resource type in its package (or choose template Both), and make sure every field type can be deep copied`
+	msgAssignedStubTypes       = `types of stubbed packages are copied by assignment; pointers, slices and maps in them are shared by copies`
+)
+
+// DeepCopyFile is the data of template deepcopy.
+type DeepCopyFile struct {
+	Package string
+	Imports []string
+	Types   []DeepCopyType
+}
+
+type DeepCopyType struct {
+	Name string
+	// IsObject decides whether DeepCopyObject is generated.
+	IsObject bool
+	// Body is the body of DeepCopyInto after "*out = *in".
+	Body string
+}
+
+// deepCopyGen generates DeepCopyInto for types of a package, the way deepcopy-gen does.
+//
+// Generated files in the same package share one deepCopyGen,
+// so that a type used by several resources is generated only once.
+type deepCopyGen struct {
+	pkg *loadedPackage
+
+	// planned is the set of local types whose methods are generated
+	planned sets.Set[string]
+	// imports of the current file, path -> alias
+	imports map[string]string
+	aliases map[string]string
+	names   map[string]string
+	shallow map[types.Type]bool
+	// assigned is the set of types of stubbed packages whose kind is unknown, copied by assignment
+	assigned sets.Set[string]
+}
+
+func newDeepCopyGen(pkg *loadedPackage) *deepCopyGen {
+	return &deepCopyGen{
+		pkg:      pkg,
+		planned:  sets.New[string](),
+		shallow:  make(map[types.Type]bool),
+		assigned: sets.New[string](),
+	}
+}
+
+// generate returns the deepcopy file of roots and the local types they use,
+// except types that are already generated by previous calls.
+func (g *deepCopyGen) generate(roots ...string) (*DeepCopyFile, error) {
+	g.imports, g.aliases, g.names = make(map[string]string), make(map[string]string), make(map[string]string)
+	queue := make([]*types.Named, 0, len(roots))
+	for _, name := range roots {
+		obj, ok := g.pkg.types.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s", msgTypeNotFound, name)
+		}
+		named, ok := obj.Type().(*types.Named)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s", msgTypeNotStruct, name)
+		}
+		if _, ok := named.Underlying().(*types.Struct); !ok {
+			return nil, fmt.Errorf("%s: %s", msgTypeNotStruct, name)
+		}
+		if g.planned.Has(name) {
+			continue
+		}
+		g.planned.Insert(name)
+		queue = append(queue, named)
+	}
+	objects := len(queue)
+
+	file := &DeepCopyFile{Package: g.pkg.types.Name()}
+	for i := 0; i < len(queue); i++ {
+		named := queue[i]
+		// plan local structs used by this type before generating the body,
+		// so that their DeepCopyInto can be called
+		for _, dep := range g.localStructs(named.Underlying(), sets.New[types.Type]()) {
+			if g.planned.Has(dep.Obj().Name()) || hasDeepCopyInto(dep) {
+				continue
+			}
+			g.planned.Insert(dep.Obj().Name())
+			queue = append(queue, dep)
+		}
+		var sb strings.Builder
+		st := named.Underlying().(*types.Struct)
+		for j := 0; j < st.NumFields(); j++ {
+			f := st.Field(j)
+			if g.isShallow(f.Type()) {
+				continue
+			}
+			if err := g.copy(&sb, f.Type(), "in."+f.Name(), "out."+f.Name()); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", named.Obj().Name(), f.Name(), err)
+			}
+		}
+		file.Types = append(file.Types, DeepCopyType{
+			Name:     named.Obj().Name(),
+			IsObject: i < objects,
+			Body:     strings.TrimSuffix(sb.String(), NewLine),
+		})
+	}
+	for _, t := range file.Types {
+		if t.IsObject {
+			g.qualifier(types.NewPackage("k8s.io/apimachinery/pkg/runtime", "runtime"))
+			break
+		}
+	}
+	for p, alias := range g.imports {
+		if alias == g.names[p] {
+			file.Imports = append(file.Imports, `"`+p+`"`)
+			continue
+		}
+		file.Imports = append(file.Imports, alias+` "`+p+`"`)
+	}
+	sort.Strings(file.Imports)
+	return file, nil
+}
+
+// localStructs returns the named structs of the local package used by t,
+// without looking into types that have DeepCopyInto.
+func (g *deepCopyGen) localStructs(t types.Type, seen sets.Set[types.Type]) []*types.Named {
+	if seen.Has(t) {
+		return nil
+	}
+	seen.Insert(t)
+	var result []*types.Named
+	if named, ok := t.(*types.Named); ok {
+		if _, ok := named.Underlying().(*types.Struct); ok {
+			if named.Obj().Pkg() != g.pkg.types || hasDeepCopyInto(named) {
+				return nil
+			}
+			result = append(result, named)
+		}
+		t = named.Underlying()
+	}
+	switch u := t.(type) {
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			result = append(result, g.localStructs(u.Field(i).Type(), seen)...)
+		}
+	case *types.Pointer:
+		result = append(result, g.localStructs(u.Elem(), seen)...)
+	case *types.Slice:
+		result = append(result, g.localStructs(u.Elem(), seen)...)
+	case *types.Array:
+		result = append(result, g.localStructs(u.Elem(), seen)...)
+	case *types.Map:
+		result = append(result, g.localStructs(u.Elem(), seen)...)
+	}
+	return result
+}
+
+// isShallow reports whether t can be deep copied by assignment.
+func (g *deepCopyGen) isShallow(t types.Type) bool {
+	if v, ok := g.shallow[t]; ok {
+		return v
+	}
+	// pointers, slices and maps stop recursion, so a temporary value is not needed
+	var v bool
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		v = true
+	case *types.Struct:
+		if named, ok := t.(*types.Named); ok && g.isStub(named) {
+			v = !hasDeepCopyInto(named)
+			if v && !knownShallowTypes.Has(types.TypeString(named, nil)) {
+				g.assigned.Insert(types.TypeString(named, nil))
+			}
+			break
+		}
+		v = true
+		for i := 0; i < u.NumFields() && v; i++ {
+			v = g.isShallow(u.Field(i).Type())
+		}
+	case *types.Array:
+		v = g.isShallow(u.Elem())
+	}
+	g.shallow[t] = v
+	return v
+}
+
+func (g *deepCopyGen) isStub(named *types.Named) bool {
+	return named.Obj().Pkg() != nil && g.pkg.stubs.Has(named.Obj().Pkg().Path())
+}
+
+// callsDeepCopyInto reports whether t is a struct that is copied by its own DeepCopyInto.
+func (g *deepCopyGen) callsDeepCopyInto(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	if _, ok = named.Underlying().(*types.Struct); !ok {
+		return false
+	}
+	return hasDeepCopyInto(named) || named.Obj().Pkg() == g.pkg.types && g.planned.Has(named.Obj().Name())
+}
+
+func hasDeepCopyInto(named *types.Named) bool {
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(named), false, named.Obj().Pkg(), "DeepCopyInto")
+	_, ok := obj.(*types.Func)
+	return ok
+}
+
+// copy writes statements that deep copy in to out, which already holds a shallow copy of in.
+// in and out must be addressable.
+func (g *deepCopyGen) copy(sb *strings.Builder, t types.Type, in, out string) error {
+	if g.callsDeepCopyInto(t) {
+		fmt.Fprintf(sb, "%s.DeepCopyInto(&%s)\n", in, out)
+		return nil
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Pointer:
+		fmt.Fprintf(sb, "if %s != nil {\nin, out := &%s, &%s\n*out = new(%s)\n", in, in, out, g.typeString(u.Elem()))
+		switch {
+		case g.callsDeepCopyInto(u.Elem()):
+			sb.WriteString("(*in).DeepCopyInto(*out)\n")
+		case g.isShallow(u.Elem()):
+			sb.WriteString("**out = **in\n")
+		default:
+			sb.WriteString("**out = **in\n")
+			if err := g.copy(sb, u.Elem(), "(**in)", "(**out)"); err != nil {
+				return err
+			}
+		}
+		sb.WriteString("}\n")
+	case *types.Slice:
+		fmt.Fprintf(sb, "if %s != nil {\nin, out := &%s, &%s\n*out = make(%s, len(*in))\n", in, in, out, g.typeString(t))
+		if g.isShallow(u.Elem()) || !g.callsDeepCopyInto(u.Elem()) {
+			sb.WriteString("copy(*out, *in)\n")
+		}
+		if !g.isShallow(u.Elem()) {
+			sb.WriteString("for i := range *in {\n")
+			if err := g.copy(sb, u.Elem(), "(*in)[i]", "(*out)[i]"); err != nil {
+				return err
+			}
+			sb.WriteString("}\n")
+		}
+		sb.WriteString("}\n")
+	case *types.Array:
+		fmt.Fprintf(sb, "{\nin, out := &%s, &%s\nfor i := range *in {\n", in, out)
+		if err := g.copy(sb, u.Elem(), "(*in)[i]", "(*out)[i]"); err != nil {
+			return err
+		}
+		sb.WriteString("}\n}\n")
+	case *types.Map:
+		fmt.Fprintf(sb, "if %s != nil {\nin, out := &%s, &%s\n*out = make(%s, len(*in))\nfor key, val := range *in {\n", in, in, out, g.typeString(t))
+		switch {
+		case g.isShallow(u.Elem()):
+			sb.WriteString("(*out)[key] = val\n")
+		case g.callsDeepCopyInto(u.Elem()):
+			fmt.Fprintf(sb, "var outVal %s\nval.DeepCopyInto(&outVal)\n(*out)[key] = outVal\n", g.typeString(u.Elem()))
+		default:
+			sb.WriteString("outVal := val\n")
+			if err := g.copy(sb, u.Elem(), "val", "outVal"); err != nil {
+				return err
+			}
+			sb.WriteString("(*out)[key] = outVal\n")
+		}
+		sb.WriteString("}\n}\n")
+	case *types.Struct:
+		if _, ok := t.(*types.Named); ok {
+			// a struct of another package without DeepCopyInto
+			return fmt.Errorf("%s: %s", msgNoDeepCopyInto, g.typeString(t))
+		}
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			if g.isShallow(f.Type()) {
+				continue
+			}
+			if err := g.copy(sb, f.Type(), in+"."+f.Name(), out+"."+f.Name()); err != nil {
+				return err
+			}
+		}
+	case *types.Interface:
+		if types.TypeString(t, nil) != runtimeObject {
+			return fmt.Errorf("%s: %s", msgUnsupportedDeepCopyType, g.typeString(t))
+		}
+		fmt.Fprintf(sb, "if %s != nil {\n%s = %s.DeepCopyObject()\n}\n", in, out, in)
+	default:
+		return fmt.Errorf("%s: %s", msgUnsupportedDeepCopyType, g.typeString(t))
+	}
+	return nil
+}
+
+func (g *deepCopyGen) typeString(t types.Type) string {
+	return types.TypeString(t, g.qualifier)
+}
+
+// qualifier returns the alias of p and adds it to imports.
+func (g *deepCopyGen) qualifier(p *types.Package) string {
+	if p == g.pkg.types {
+		return ""
+	}
+	if alias, ok := g.imports[p.Path()]; ok {
+		return alias
+	}
+	alias := getAlias(p.Path())
+	for i := 2; ; i++ {
+		if _, taken := g.aliases[alias]; !taken {
+			break
+		}
+		alias = fmt.Sprintf("%s%d", getAlias(p.Path()), i)
+	}
+	g.imports[p.Path()], g.aliases[alias], g.names[p.Path()] = alias, p.Path(), p.Name()
+	return alias
+}
+
+// CreateOrRewriteDeepCopy generates field-by-field deepcopy methods for custom resources.
+//
+// The resource types are loaded with go/types from the resource package.
+// Local types used by a resource get their own DeepCopyInto, unless they already have one.
+func CreateOrRewriteDeepCopy(tx *Transaction, tmpl *template.Template, config *Controller) error {
+	gens := make(map[string]*deepCopyGen)
+	for _, r := range config.generatedResources() {
+		if r.Template != TemplateDeepCopy && r.Template != TemplateBoth {
+			continue
+		}
+
+		gen, ok := gens[r.Dir]
+		if !ok {
+			pkg, err := config.resourcePackage(tx, r.Dir)
+			if err != nil {
+				log.Error("failed to load package", "package", r.Package, "directory", r.Dir, "cause", err)
+				return err
+			}
+			gen = newDeepCopyGen(pkg)
+			gens[r.Dir] = gen
+		}
+
+		roots := []string{r.Kind}
+		if _, ok := gen.pkg.types.Scope().Lookup(r.Kind + "List").(*types.TypeName); ok {
+			roots = append(roots, r.Kind+"List")
+		}
+		file, err := gen.generate(roots...)
+		if err != nil {
+			log.Error("failed to generate deepcopy", "resource", r.Kind, "package", r.Package, "cause", err, "tip", msgDeepCopyTip)
+			return errors.New(msgFailedToGenDeepCopy)
+		}
+
+		fp := filepath.Join(r.Dir, r.LowerKind+generatedFileSuffix)
+		log.Info("write deepcopy", "resource", r.Kind, "file", fp)
+		b, err := renderGo(tmpl, file)
+		if err != nil {
+			return err
+		}
+		tx.Add(fp, b)
+	}
+	for _, dir := range sets.List(sets.KeySet(gens)) {
+		if assigned := gens[dir].assigned; assigned.Len() > 0 {
+			log.Warn(msgAssignedStubTypes, "types", strings.Join(sets.List(assigned), ", "), "tip", msgStubbedPackagesTip)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// deepCopyTypes are types with pointer, map, slice and array fields of basic types and structs.
+const deepCopyTypes = `package v1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+type Foo struct {
+	metav1.TypeMeta   ` + "`json:\",inline\"`" + `
+	metav1.ObjectMeta ` + "`json:\"metadata,omitempty\"`" + `
+
+	Spec FooSpec ` + "`json:\"spec\"`" + `
+}
+
+type FooList struct {
+	metav1.TypeMeta ` + "`json:\",inline\"`" + `
+	metav1.ListMeta ` + "`json:\"metadata,omitempty\"`" + `
+
+	Items []Foo ` + "`json:\"items\"`" + `
+}
+
+type FooSpec struct {
+	Replicas    *int32
+	Labels      map[string]string
+	Items       []Item
+	ItemsByName map[string]Item
+	ItemPtrs    []*Item
+	Pair        [2]Item
+	Shallow     Point
+	Started     *metav1.Time
+}
+
+type Item struct {
+	Name string
+	Tags []string
+	Next *Item
+}
+
+// Point is copied by assignment.
+type Point struct {
+	X, Y int
+}
+`
+
+// deepCopyTest checks that copies share nothing with the original.
+const deepCopyTest = `package v1
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newFoo() *Foo {
+	replicas := int32(3)
+	started := metav1.Unix(1, 0)
+	return &Foo{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Labels: map[string]string{"app": "foo"}},
+		Spec: FooSpec{
+			Replicas:    &replicas,
+			Labels:      map[string]string{"a": "b"},
+			Items:       []Item{{Name: "x", Tags: []string{"t"}, Next: &Item{Name: "y", Tags: []string{"u"}}}},
+			ItemsByName: map[string]Item{"x": {Name: "x", Tags: []string{"t"}}},
+			ItemPtrs:    []*Item{{Name: "p", Tags: []string{"t"}}, nil},
+			Pair:        [2]Item{{Tags: []string{"first"}}, {}},
+			Shallow:     Point{X: 1},
+			Started:     &started,
+		},
+	}
+}
+
+func TestDeepCopy(t *testing.T) {
+	in := newFoo()
+	out := in.DeepCopy()
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("copy differs:\n%+v\n%+v", in, out)
+	}
+
+	*out.Spec.Replicas = 5
+	out.Spec.Labels["a"] = "changed"
+	out.Spec.Items[0].Tags[0] = "changed"
+	out.Spec.Items[0].Next.Name = "changed"
+	out.Spec.Items[0].Next.Tags[0] = "changed"
+	out.Spec.ItemsByName["x"].Tags[0] = "changed"
+	out.Spec.ItemPtrs[0].Name = "changed"
+	out.Spec.ItemPtrs[0].Tags[0] = "changed"
+	out.Spec.Pair[0].Tags[0] = "changed"
+	*out.Spec.Started = metav1.Unix(2, 0)
+	out.Labels["app"] = "changed"
+
+	if want := newFoo(); !reflect.DeepEqual(in, want) {
+		t.Errorf("original is changed through the copy:\n%+v\nwant\n%+v", in, want)
+	}
+	if out.Spec.ItemPtrs[1] != nil {
+		t.Error("nil pointer in slice is not kept")
+	}
+}
+
+func TestDeepCopyNil(t *testing.T) {
+	in := &Foo{}
+	out := in.DeepCopy()
+	if out.Spec.Replicas != nil || out.Spec.Labels != nil || out.Spec.Items != nil {
+		t.Errorf("nil fields are not kept: %+v", out.Spec)
+	}
+	var nilFoo *Foo
+	if nilFoo.DeepCopy() != nil || nilFoo.DeepCopyObject() != nil {
+		t.Error("copy of nil is not nil")
+	}
+}
+
+func TestDeepCopyList(t *testing.T) {
+	in := &FooList{Items: []Foo{*newFoo()}}
+	out := in.DeepCopyObject().(*FooList)
+	out.Items[0].Spec.Items[0].Tags[0] = "changed"
+	if in.Items[0].Spec.Items[0].Tags[0] != "t" {
+		t.Error("original is changed through the copy of the list")
+	}
+}
+`
+
+func TestDeepCopyBody(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"types.go": deepCopyTypes})
+	pkg, err := loadPackage(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := newDeepCopyGen(pkg).generate("Foo", "FooList")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := make(map[string]string)
+	objects := make(map[string]bool)
+	for _, typ := range file.Types {
+		bodies[typ.Name] = typ.Body
+		objects[typ.Name] = typ.IsObject
+	}
+
+	tests := []struct {
+		name     string
+		typ      string
+		isObject bool
+		// contains are statements expected in the body of DeepCopyInto
+		contains []string
+		// excludes are statements that must not be in the body
+		excludes []string
+	}{
+		{
+			name:     "object",
+			typ:      "Foo",
+			isObject: true,
+			contains: []string{"in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)", "in.Spec.DeepCopyInto(&out.Spec)"},
+			excludes: []string{"TypeMeta"},
+		},
+		{
+			name:     "list",
+			typ:      "FooList",
+			isObject: true,
+			contains: []string{"*out = make([]Foo, len(*in))", "(*in)[i].DeepCopyInto(&(*out)[i])"},
+		},
+		{
+			name: "pointer to basic type",
+			typ:  "FooSpec",
+			contains: []string{
+				"in, out := &in.Replicas, &out.Replicas",
+				"*out = new(int32)",
+				"**out = **in",
+			},
+		},
+		{
+			name: "map of basic type",
+			typ:  "FooSpec",
+			contains: []string{
+				"*out = make(map[string]string, len(*in))",
+				"(*out)[key] = val",
+			},
+		},
+		{
+			name: "slice of struct",
+			typ:  "FooSpec",
+			contains: []string{
+				"*out = make([]Item, len(*in))",
+				"(*in)[i].DeepCopyInto(&(*out)[i])",
+			},
+		},
+		{
+			name: "map of struct",
+			typ:  "FooSpec",
+			contains: []string{
+				"*out = make(map[string]Item, len(*in))",
+				"val.DeepCopyInto(&outVal)",
+			},
+		},
+		{
+			name: "slice of pointers",
+			typ:  "FooSpec",
+			contains: []string{
+				"*out = make([]*Item, len(*in))",
+				"(*in).DeepCopyInto(*out)",
+			},
+		},
+		{
+			name:     "shallow struct",
+			typ:      "FooSpec",
+			excludes: []string{"Shallow"},
+		},
+		{
+			name:     "struct of another package",
+			typ:      "FooSpec",
+			contains: []string{"*out = new(metav1.Time)"},
+		},
+		{
+			name:     "local struct used by the object",
+			typ:      "Item",
+			contains: []string{"*out = make([]string, len(*in))", "copy(*out, *in)", "*out = new(Item)", "(*in).DeepCopyInto(*out)"},
+			excludes: []string{"in.Name"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, ok := bodies[tt.typ]
+			if !ok {
+				t.Fatalf("DeepCopyInto of %s is not generated", tt.typ)
+			}
+			if objects[tt.typ] != tt.isObject {
+				t.Errorf("IsObject = %v, want %v", objects[tt.typ], tt.isObject)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(body, want) {
+					t.Errorf("missing %q in:\n%s", want, body)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(body, unwanted) {
+					t.Errorf("unexpected %q in:\n%s", unwanted, body)
+				}
+			}
+		})
+	}
+}
+
+func TestDeepCopyCompiles(t *testing.T) {
+	dir := newGoModule(t, map[string]string{
+		"api/v1/types.go":    deepCopyTypes,
+		"api/v1/foo_test.go": deepCopyTest,
+	})
+	pkgDir := filepath.Join(dir, "api", "v1")
+	pkg, err := loadPackage(pkgDir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.stubs.Len() > 0 {
+		t.Fatalf("packages are stubbed: %v", pkg.stubs.UnsortedList())
+	}
+	file, err := newDeepCopyGen(pkg).generate("Foo", "FooList")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := renderGo(parseTemplate(t, "deepcopy.go.tmpl"), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(pkgDir, "foo"+generatedFileSuffix), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGoTest(t, dir)
+}
+
+// stubbedTypes use external types of different kinds, which are stubbed without a go.mod.
+const stubbedTypes = `package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type Bar struct {
+	metav1.TypeMeta   ` + "`json:\",inline\"`" + `
+	metav1.ObjectMeta ` + "`json:\"metadata,omitempty\"`" + `
+
+	Phase     corev1.PodPhase
+	Resources corev1.ResourceList
+	Template  *corev1.PodTemplateSpec
+	Phases    []corev1.PodPhase
+	Limits    map[string]corev1.ResourceList
+}
+`
+
+func TestDeepCopyStubbed(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"types.go": stubbedTypes})
+	pkg, err := loadPackage(dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pkg.stubs.Has("k8s.io/api/core/v1") {
+		t.Fatalf("core/v1 is not stubbed: %v", pkg.stubs.UnsortedList())
+	}
+	gen := newDeepCopyGen(pkg)
+	file, err := gen.generate("Bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := file.Types[0].Body
+	for _, want := range []string{
+		"in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)",
+		"**out = **in",
+		"copy(*out, *in)",
+		"(*out)[key] = val",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+	for _, unwanted := range []string{"&in.Phase,", "&in.Resources,", "(*in).DeepCopyInto"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("unexpected %q in:\n%s", unwanted, body)
+		}
+	}
+	want := []string{"k8s.io/api/core/v1.PodPhase", "k8s.io/api/core/v1.PodTemplateSpec", "k8s.io/api/core/v1.ResourceList"}
+	if got := sets.List(gen.assigned); !reflect.DeepEqual(got, want) {
+		t.Errorf("assigned = %v, want %v", got, want)
+	}
+
+	// code generated from stubs compiles against the real types
+	b, err := renderGo(parseTemplate(t, "deepcopy.go.tmpl"), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := newGoModule(t, map[string]string{
+		"api/v1/types.go":                  stubbedTypes,
+		"api/v1/bar" + generatedFileSuffix: string(b),
+	})
+	runGoTest(t, module)
+}
+
+func TestLoadPackageWithGoMod(t *testing.T) {
+	// resolve the requirements of generatedGoMod into the module cache
+	newGoModule(t, nil)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"types.go": deepCopyTypes})
+	pkg, err := loadPackage(dir, nil, []byte(generatedGoMod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.stubs.Len() > 0 {
+		t.Errorf("packages are stubbed with go.mod to be written: %v", pkg.stubs.UnsortedList())
+	}
+}
@@ -0,0 +1,141 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	msgDefinitionConflict    = `some types of the definition are already declared in package`
+	msgDefinitionConflictTip = `rename or remove the declared types, or set template to 2 to generate deepcopy only`
+)
+
+// definitionFileName returns the name of the file that holds the definition of r.
+func definitionFileName(r *Resource) string {
+	return "types_" + r.LowerKind + ".go"
+}
+
+// CreateOrUpdateDefinition generates the definition structs of custom resources
+// into types_<kind>.go of the resource package.
+//
+// The file belongs to the user once created. On regeneration, only the imports and types
+// that are missing in the package are added, so user-added fields are never lost.
+func CreateOrUpdateDefinition(tx *Transaction, tmpl *template.Template, config *Controller) error {
+	for _, r := range config.generatedResources() {
+		if r.Template != TemplateDefinition && r.Template != TemplateBoth {
+			continue
+		}
+		fp := filepath.Join(r.Dir, definitionFileName(r))
+		b2, err := renderGo(tmpl, r)
+		if err != nil {
+			return err
+		}
+		cur, err := parser.ParseFile(token.NewFileSet(), "", b2, parser.AllErrors|parser.ParseComments)
+		if err != nil {
+			log.Error("failed to parse AST from new template", "template", tmpl.Name(), "cause", err)
+			return err
+		}
+		// identifiers declared in other files of the package, e.g. an existing foo_types.go
+		declared, err := declaredInDir(r.Dir, fp)
+		if err != nil {
+			return err
+		}
+
+		b1, err := os.ReadFile(fp)
+		if os.IsNotExist(err) {
+			if declared.Has(r.Kind) {
+				log.Info("definition already exists in package; skip", "resource", r.Kind, "directory", r.Dir)
+				continue
+			}
+			if conflicts := sets.New(typeDecls(cur)...).Intersection(declared); conflicts.Len() > 0 {
+				log.Error(msgDefinitionConflict, "resource", r.Kind, "types", sets.List(conflicts), "tip", msgDefinitionConflictTip)
+				return errors.New(msgDefinitionConflict)
+			}
+			log.Info("create definition", "resource", r.Kind, "file", fp)
+			r.definitionCreated = true
+			tx.Add(fp, b2)
+			continue
+		}
+		if err != nil {
+			log.Error("failed to read file", "file", fp, "cause", err)
+			return err
+		}
+
+		log.Info("update definition", "resource", r.Kind, "file", fp)
+		fset := token.NewFileSet()
+		target, err := parser.ParseFile(fset, fp, b1, parser.AllErrors|parser.ParseComments)
+		if err != nil {
+			log.Error("failed to parse AST from existing file", "file", fp, "cause", err)
+			return err
+		}
+		declared.Insert(declaredNames(target)...)
+		missing := missingTypeDecls(cur, declared)
+		if len(missing) == 0 {
+			log.Info("no new code")
+			continue
+		}
+		src, err := addMissingImports(fp, b1, fset, target, cur)
+		if err != nil {
+			log.Error("failed to add imports", "file", fp, "cause", err)
+			return err
+		}
+
+		var tmpBuf bytes.Buffer
+		tmpBuf.Write(src)
+		for _, decl := range missing {
+			log.Info("add new type", "type", decl.Specs[0].(*ast.TypeSpec).Name.Name)
+			start := decl.Pos()
+			if decl.Doc != nil {
+				start = decl.Doc.Pos()
+			}
+			tmpBuf.WriteString(NewLine)
+			tmpBuf.Write(b2[start-1 : decl.End()-1])
+			tmpBuf.WriteString(NewLine)
+		}
+		log.Info("write to file", "file", fp)
+		tx.Add(fp, tmpBuf.Bytes())
+	}
+	return nil
+}
+
+// typeDecls returns the names of the types declared in f.
+func typeDecls(f *ast.File) []string {
+	var names []string
+	for _, decl := range f.Decls {
+		g, ok := decl.(*ast.GenDecl)
+		if !ok || g.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range g.Specs {
+			names = append(names, spec.(*ast.TypeSpec).Name.Name)
+		}
+	}
+	return names
+}
+
+// missingTypeDecls returns the type declarations of f whose types are not declared.
+func missingTypeDecls(f *ast.File, declared sets.Set[string]) []*ast.GenDecl {
+	var missing []*ast.GenDecl
+	for _, decl := range f.Decls {
+		g, ok := decl.(*ast.GenDecl)
+		if !ok || g.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range g.Specs {
+			if !declared.Has(spec.(*ast.TypeSpec).Name.Name) {
+				missing = append(missing, g)
+				break
+			}
+		}
+	}
+	return missing
+}
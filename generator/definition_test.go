@@ -0,0 +1,187 @@
+package generator
+
+import (
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestCreateOrUpdateDefinition(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		// staged is false if nothing is written
+		staged  bool
+		wantErr bool
+		// types are the types declared in the staged file
+		types []string
+		// contains are strings expected in the staged file
+		contains []string
+	}{
+		{
+			name:     "new file",
+			staged:   true,
+			types:    []string{"Foo", "FooSpec", "FooStatus", "FooList"},
+			contains: []string{"package v1", `metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`},
+		},
+		{
+			name: "missing types and imports are added",
+			files: map[string]string{"types_foo.go": `package v1
+
+// Foo is defined by the user.
+type Foo struct {
+	Replicas int32
+}
+`},
+			staged: true,
+			types:  []string{"Foo", "FooSpec", "FooStatus", "FooList"},
+			contains: []string{
+				"// Foo is defined by the user.\ntype Foo struct {\n\tReplicas int32\n}",
+				`metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`,
+				"// FooList is a list of Foo.",
+			},
+		},
+		{
+			name: "existing imports are kept",
+			files: map[string]string{"types_foo.go": `package v1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type FooSpec struct {
+	Interval time.Duration
+	Since    metav1.Time
+}
+`},
+			staged:   true,
+			types:    []string{"FooSpec", "Foo", "FooStatus", "FooList"},
+			contains: []string{"import (\n\t\"time\"\n\n\tmetav1 \"k8s.io/apimachinery/pkg/apis/meta/v1\"\n)"},
+		},
+		{
+			name: "types declared in other files are not added",
+			files: map[string]string{
+				"types_foo.go": "package v1\n\ntype Foo struct{}\n",
+				"list.go":      "package v1\n\ntype FooList struct{}\n",
+			},
+			staged: true,
+			types:  []string{"Foo", "FooSpec", "FooStatus"},
+		},
+		{
+			name:  "up to date",
+			files: map[string]string{"types_foo.go": "package v1\n\ntype Foo struct{}\ntype FooSpec struct{}\ntype FooStatus struct{}\ntype FooList struct{}\n"},
+		},
+		{
+			name:  "defined in another file",
+			files: map[string]string{"foo_types.go": "package v1\n\ntype Foo struct{}\n"},
+		},
+		{
+			name:    "conflict with another file",
+			files:   map[string]string{"spec.go": "package v1\n\ntype FooSpec struct{}\n"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			config := withVersions(&Controller{Resources: []Resource{{
+				Kind:      "Foo",
+				LowerKind: "foo",
+				IsCustom:  true,
+				Template:  TemplateBoth,
+				Dir:       dir,
+				GoPackage: "v1",
+			}}})
+			tx := NewTransaction()
+			err := CreateOrUpdateDefinition(tx, parseTemplate(t, "types.go.tmpl"), config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateOrUpdateDefinition() = %v, want error %v", err, tt.wantErr)
+			}
+			staged := tx.StagedInDir(dir)
+			fp := filepath.Join(dir, "types_foo.go")
+			b, ok := staged[fp]
+			if ok != tt.staged || len(staged) > 1 {
+				t.Fatalf("staged files = %v, want %s staged %v", sets.KeySet(staged).UnsortedList(), fp, tt.staged)
+			}
+			if !ok {
+				return
+			}
+			f, err := parser.ParseFile(token.NewFileSet(), fp, b, parser.ParseComments)
+			if err != nil {
+				t.Fatalf("staged file does not parse: %v\n%s", err, b)
+			}
+			if got := typeDecls(f); strings.Join(got, ",") != strings.Join(tt.types, ",") {
+				t.Errorf("types = %v, want %v", got, tt.types)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(string(b), want) {
+					t.Errorf("missing %q in:\n%s", want, b)
+				}
+			}
+		})
+	}
+}
+
+func TestAddMissingImports(t *testing.T) {
+	cur := `package v1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+`
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "no import declaration",
+			src:  "// Package v1 is the API.\npackage v1\n\n// Foo is a resource.\ntype Foo struct{}\n",
+			want: "// Package v1 is the API.\npackage v1\n\nimport (\n\tmetav1 \"k8s.io/apimachinery/pkg/apis/meta/v1\"\n\t\"time\"\n)\n\n// Foo is a resource.\ntype Foo struct{}\n",
+		},
+		{
+			name: "single import",
+			src:  "package v1\n\nimport \"time\" // for durations\n\n// Foo is a resource.\ntype Foo struct{}\n",
+			want: "package v1\n\nimport (\n\tmetav1 \"k8s.io/apimachinery/pkg/apis/meta/v1\"\n\t\"time\" // for durations\n)\n\n// Foo is a resource.\ntype Foo struct{}\n",
+		},
+		{
+			name: "import block",
+			src:  "package v1\n\nimport (\n\t// errors of the standard library\n\t\"errors\"\n)\n\nvar _ = errors.New\n",
+			want: "package v1\n\nimport (\n\t// errors of the standard library\n\t\"errors\"\n\n\tmetav1 \"k8s.io/apimachinery/pkg/apis/meta/v1\"\n\t\"time\"\n)\n\nvar _ = errors.New\n",
+		},
+		{
+			name: "nothing missing",
+			src:  "package v1\n\nimport (\n\tmetav1 \"k8s.io/apimachinery/pkg/apis/meta/v1\"\n\t\"time\"\n)\n",
+			want: "package v1\n\nimport (\n\tmetav1 \"k8s.io/apimachinery/pkg/apis/meta/v1\"\n\t\"time\"\n)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			target, err := parser.ParseFile(fset, "", tt.src, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			curFile, err := parser.ParseFile(token.NewFileSet(), "", cur, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := addMissingImports("types_foo.go", []byte(tt.src), fset, target, curFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
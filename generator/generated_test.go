@@ -0,0 +1,175 @@
+package generator
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// generatedGoMod is the go.mod of modules that compile generated code in tests.
+// It only requires the modules used by the generated code under test.
+const generatedGoMod = `module example.com/generated
+
+go 1.21
+
+require (
+	k8s.io/api v` + defaultK8sAPIVersion + `
+	k8s.io/apimachinery v` + defaultK8sAPIVersion + `
+	k8s.io/klog/v2 v2.110.1
+)
+`
+
+// koolStub declares the part of github.com/FlyingOnion/kool used by the generated controller,
+// so that the controller can be type-checked without downloading kool.
+const koolStub = `package kool
+
+import (
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+func NewRESTClient(config *rest.Config, httpClient *http.Client, gv *schema.GroupVersion) (*rest.RESTClient, error) {
+	return nil, nil
+}
+
+type Lister[T any] interface {
+	List(selector labels.Selector) ([]*T, error)
+	Get(name string) (*T, error)
+	Namespaced(namespace string) NamespacedLister[T]
+}
+
+type NamespacedLister[T any] interface {
+	List(selector labels.Selector) ([]*T, error)
+	Get(name string) (*T, error)
+}
+
+type Informer[T any] interface {
+	Informer() cache.SharedIndexInformer
+	Lister() Lister[T]
+}
+
+type NamespacedInformer[T any] interface {
+	Informer() cache.SharedIndexInformer
+	Lister() NamespacedLister[T]
+}
+
+func NewInformer[T any](client rest.Interface, resyncPeriod time.Duration) Informer[T] {
+	return nil
+}
+
+func NewNamespacedInformer[T any](client rest.Interface, namespace string, resyncPeriod time.Duration) NamespacedInformer[T] {
+	return nil
+}
+`
+
+// skipUnlessGo skips tests that compile generated code in short mode, or if the go command is not found.
+func skipUnlessGo(t *testing.T) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skip compiling generated code in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command is not found")
+	}
+}
+
+// goModTidy resolves the dependencies of the module in dir.
+// The test is skipped if they cannot be resolved, e.g. offline without a module cache.
+func goModTidy(t *testing.T, dir string) {
+	t.Helper()
+	cmd := exec.Command("go", "mod", "tidy")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("cannot resolve dependencies of generated code: %v\n%s", err, out)
+	}
+}
+
+// newGoModule writes files into a temporary go module and resolves its dependencies.
+func newGoModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	skipUnlessGo(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": generatedGoMod})
+	writeFiles(t, dir, files)
+	goModTidy(t, dir)
+	return dir
+}
+
+// generateController generates the go files of config into a temporary directory,
+// the way main.go does, and resolves the dependencies of the generated module,
+// with kool replaced by koolStub. files, e.g. tests, are written after generation.
+func generateController(t *testing.T, config string, files map[string]string) string {
+	t.Helper()
+	skipUnlessGo(t)
+	dir := t.TempDir()
+	generateControllerIn(t, dir, config, files)
+	return dir
+}
+
+// generateControllerIn is generateController in dir, which may have files of an earlier generation.
+func generateControllerIn(t *testing.T, dir, config string, files map[string]string) {
+	t.Helper()
+	c, err := ReadConfigFromReader(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Base = dir
+	if err = c.InitAndValidate(); err != nil {
+		t.Fatal(err)
+	}
+	tx := NewTransaction()
+	for _, step := range []struct {
+		fn   func(*Transaction, *template.Template, *Controller) error
+		tmpl string
+	}{
+		{CreateOrRewriteGoMod, "gomod.tmpl"},
+		{CreateOrRewrite, "main.go.tmpl"},
+		{CreateOrRewrite, "controller.go.tmpl"},
+		{CreateOrRewriteClients, "clients.go.tmpl"},
+		{CreateOrRewriteMetadataInformer, "metadata_informer.go.tmpl"},
+		{CreateOrUpdateCustom, "event_handler.go.tmpl"},
+		{CreateOrUpdateDefinition, "types.go.tmpl"},
+		{CreateConversion, "conversion.go.tmpl"},
+		{CreateOrRewriteRegister, "register.go.tmpl"},
+		{CreateOrRewriteDeepCopy, "deepcopy.go.tmpl"},
+		{CreateOrRewriteValidate, "validate.go.tmpl"},
+		{CreateOrRewriteWebhook, "conversion_webhook.go.tmpl"},
+	} {
+		if err = step.fn(tx, parseTemplate(t, step.tmpl), c); err != nil {
+			t.Fatalf("%s: %v", step.tmpl, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{
+		"go.mod":       string(goMod) + "\nreplace github.com/FlyingOnion/kool => ./kool\n",
+		"kool/go.mod":  "module github.com/FlyingOnion/kool\n\ngo 1.21\n",
+		"kool/kool.go": koolStub,
+	})
+	writeFiles(t, dir, files)
+	goModTidy(t, dir)
+}
+
+// runGoTest runs the tests of the module in dir.
+func runGoTest(t *testing.T, dir string) {
+	t.Helper()
+	cmd := exec.Command("go", "test", "./...")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test: %v\n%s", err, out)
+	}
+}
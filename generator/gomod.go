@@ -0,0 +1,81 @@
+package generator
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/FlyingOnion/pkg/log"
+	"golang.org/x/mod/modfile"
+)
+
+// mergeGoMod updates the go directive and the requirements managed by koolbuilder
+// in an existing go.mod.
+//
+// The expected versions are taken from the rendered go.mod template,
+// so gomod.tmpl stays the only place where they are declared.
+// Other requirements, replaces and comments of the existing file are kept.
+func mergeGoMod(goModTmpl *template.Template, config *Controller, existing []byte) ([]byte, bool, error) {
+	var buf bytes.Buffer
+	if err := goModTmpl.Execute(&buf, config); err != nil {
+		log.Error("failed to execute template", "template", goModTmpl.Name(), "cause", err)
+		return nil, false, err
+	}
+	expected, err := modfile.Parse(goModTmpl.Name(), buf.Bytes(), nil)
+	if err != nil {
+		log.Error("failed to parse go.mod from template", "template", goModTmpl.Name(), "cause", err)
+		return nil, false, err
+	}
+	current, err := modfile.Parse("go.mod", existing, nil)
+	if err != nil {
+		log.Error("failed to parse existing go.mod", "cause", err)
+		return nil, false, err
+	}
+
+	changed := false
+	if expected.Go != nil && (current.Go == nil || current.Go.Version != expected.Go.Version) {
+		// log the whole directive; a bare version like "1.21" is valid json
+		// and cannot be written by the logger
+		from := "<none>"
+		if current.Go != nil {
+			from = "go " + current.Go.Version
+		}
+		if err = current.AddGoStmt(expected.Go.Version); err != nil {
+			log.Error("failed to update go directive", "to", "go "+expected.Go.Version, "cause", err)
+			return nil, false, err
+		}
+		log.Info("update go directive", "from", from, "to", "go "+expected.Go.Version)
+		changed = true
+	}
+
+	currentVersions := make(map[string]string, len(current.Require))
+	for _, r := range current.Require {
+		currentVersions[r.Mod.Path] = r.Mod.Version
+	}
+	for _, r := range expected.Require {
+		from, ok := currentVersions[r.Mod.Path]
+		if ok && from == r.Mod.Version {
+			continue
+		}
+		if err = current.AddRequire(r.Mod.Path, r.Mod.Version); err != nil {
+			log.Error("failed to update requirement", "module", r.Mod.Path, "version", r.Mod.Version, "cause", err)
+			return nil, false, err
+		}
+		if ok {
+			log.Info("update requirement", "module", r.Mod.Path, "from", from, "to", r.Mod.Version)
+		} else {
+			log.Info("add requirement", "module", r.Mod.Path, "version", r.Mod.Version)
+		}
+		changed = true
+	}
+	if !changed {
+		return existing, false, nil
+	}
+
+	current.Cleanup()
+	b, err := current.Format()
+	if err != nil {
+		log.Error("failed to format go.mod", "cause", err)
+		return nil, false, err
+	}
+	return b, true, nil
+}
@@ -0,0 +1,137 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+// parseTemplate parses a template of tmpl/ the way embed.go does.
+// The template is named after the file without extensions, e.g. "main" of main.go.tmpl.
+func parseTemplate(t *testing.T, file string) *template.Template {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("..", "tmpl", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return template.Must(template.New(strings.TrimSuffix(strings.TrimSuffix(file, ".tmpl"), ".go")).Funcs(sprig.FuncMap()).Parse(string(b)))
+}
+
+func TestMergeGoMod(t *testing.T) {
+	tmpl := parseTemplate(t, "gomod.tmpl")
+	config := &Controller{Go: GoConfig{Module: "example.com/foo", Version: "1.21", K8sAPIVersion: "0.28.4"}}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, config); err != nil {
+		t.Fatal(err)
+	}
+	upToDate := sb.String()
+
+	tests := []struct {
+		name     string
+		existing string
+		changed  bool
+		// contains are lines expected in the result
+		contains []string
+		// excludes are lines that must not be in the result
+		excludes []string
+	}{
+		{
+			name:     "up to date",
+			existing: upToDate,
+			changed:  false,
+		},
+		{
+			name: "keep replaces, comments and other requirements",
+			existing: `// the operator of foo
+module example.com/foo
+
+go 1.20
+
+require (
+	github.com/FlyingOnion/kool v0.1.3
+	github.com/spf13/pflag v1.0.5
+	k8s.io/apimachinery v0.27.1 // pinned by the platform team
+	k8s.io/client-go v0.27.1
+	k8s.io/klog/v2 v2.110.1
+	sigs.k8s.io/yaml v1.3.0
+)
+
+// use the local fork until it is released
+replace github.com/FlyingOnion/kool => ../kool
+`,
+			changed: true,
+			contains: []string{
+				"// the operator of foo",
+				"go 1.21",
+				"k8s.io/apimachinery v0.28.4 // pinned by the platform team",
+				"k8s.io/client-go v0.28.4",
+				"sigs.k8s.io/yaml v1.3.0",
+				"// use the local fork until it is released",
+				"replace github.com/FlyingOnion/kool => ../kool",
+			},
+			excludes: []string{"go 1.20", "v0.27.1"},
+		},
+		{
+			name: "add missing requirements",
+			existing: `module example.com/foo
+
+go 1.21
+
+require github.com/spf13/pflag v1.0.5
+`,
+			changed: true,
+			contains: []string{
+				"github.com/FlyingOnion/kool v0.1.3",
+				"github.com/spf13/pflag v1.0.5",
+				"k8s.io/klog/v2 v2.110.1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, changed, err := mergeGoMod(tmpl, config, []byte(tt.existing))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			if !changed && string(b) != tt.existing {
+				t.Errorf("unchanged go.mod is rewritten:\n%s", b)
+			}
+			lines := strings.Split(string(b), NewLine)
+			for _, want := range tt.contains {
+				if !hasLine(lines, want) {
+					t.Errorf("missing line %q in:\n%s", want, b)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(string(b), unwanted) {
+					t.Errorf("unexpected %q in:\n%s", unwanted, b)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeGoModInvalid(t *testing.T) {
+	tmpl := parseTemplate(t, "gomod.tmpl")
+	config := &Controller{Go: GoConfig{Module: "example.com/foo", Version: "1.21", K8sAPIVersion: "0.28.4"}}
+	if _, _, err := mergeGoMod(tmpl, config, []byte("module example.com/foo\nrequire (\n")); err == nil {
+		t.Error("expected an error for an invalid go.mod")
+	}
+}
+
+// hasLine reports whether a line of lines, without surrounding spaces, is want.
+func hasLine(lines []string, want string) bool {
+	for _, line := range lines {
+		if strings.TrimSpace(line) == want {
+			return true
+		}
+	}
+	return false
+}
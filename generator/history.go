@@ -0,0 +1,361 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/FlyingOnion/pkg/log"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	historyDir           = ".koolbuilder/history"
+	snapshotManifestFile = "manifest.yaml"
+	snapshotConfigFile   = "config.yaml"
+	snapshotFilesDir     = "files"
+	snapshotTimeFormat   = "20060102-150405.000"
+
+	defaultHistoryRetain = 10
+)
+
+const (
+	msgInvalidHistoryRetain = `history.retain must not be negative`
+	msgNothingToRestore     = `no file existed before; skip snapshot`
+)
+
+type HistoryConfig struct {
+	// Retain is the number of snapshots to keep. 0 disables snapshots.
+	Retain int `yaml:"retain"`
+}
+
+func (c *Controller) initHistory() error {
+	if c.History.Retain < 0 {
+		log.Error(msgConfigInvalid, "cause", msgInvalidHistoryRetain)
+		return errors.New(msgConfigInvalid)
+	}
+	return nil
+}
+
+// Snapshot is a backup of the files in config.Base before a regeneration.
+type Snapshot struct {
+	Name  string         `yaml:"-"`
+	Time  time.Time      `yaml:"time"`
+	Files []SnapshotFile `yaml:"files"`
+	// Dirs are the directories created by the regeneration, relative to config.Base.
+	Dirs []string `yaml:"dirs,omitempty"`
+
+	// Config is the configuration that produced the regeneration.
+	Config []byte `yaml:"-"`
+}
+
+type SnapshotFile struct {
+	// Path is relative to config.Base.
+	Path    string `yaml:"path"`
+	Existed bool   `yaml:"existed"`
+}
+
+// SaveSnapshot copies the current content of every file that the uncommitted tx will change
+// into .koolbuilder/history/<timestamp>/ and prunes old snapshots.
+// It must be called before tx.Commit, so that nothing is overwritten without a backup.
+// Nothing is saved if no file will change, or if every changed file is new (e.g. on the first run).
+func SaveSnapshot(tx *Transaction, config *Controller) error {
+	if config.History.Retain == 0 {
+		return nil
+	}
+	changed, err := tx.pending()
+	if err != nil {
+		log.Error("failed to read files", "cause", err)
+		return err
+	}
+	if len(changed) == 0 {
+		log.Info("no file changes; skip snapshot")
+		return nil
+	}
+	if !slices.ContainsFunc(changed, func(state previousState) bool { return state.existed }) {
+		log.Info(msgNothingToRestore)
+		return nil
+	}
+
+	now := time.Now()
+	w := &snapshotWriter{
+		base: config.Base,
+		dir:  filepath.Join(config.Base, historyDir, now.Format(snapshotTimeFormat)),
+		snapshot: Snapshot{
+			Time:  now,
+			Files: make([]SnapshotFile, 0, len(changed)),
+			Dirs:  createdDirs(config.Base, changed),
+		},
+	}
+	log.Info("save snapshot", "directory", w.dir)
+	if err = os.MkdirAll(filepath.Join(w.dir, snapshotFilesDir), os.ModePerm); err != nil {
+		log.Error("failed to create directory", "directory", w.dir, "cause", err)
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(w.dir, snapshotConfigFile), config.source, 0644); err != nil {
+		log.Error("failed to write file", "file", filepath.Join(w.dir, snapshotConfigFile), "cause", err)
+		w.discard()
+		return err
+	}
+	if err = w.add(changed...); err != nil {
+		w.discard()
+		return err
+	}
+	if err = pruneSnapshots(config.Base, config.History.Retain); err != nil {
+		w.discard()
+		return err
+	}
+	tx.snapshot = w
+	return nil
+}
+
+// createdDirs returns the missing directories under base that Commit will create
+// to write the files that do not exist yet, relative to base.
+func createdDirs(base string, states []previousState) []string {
+	dirs := sets.New[string]()
+	for _, state := range states {
+		if state.existed {
+			continue
+		}
+		for d := filepath.Dir(state.path); ; d = filepath.Dir(d) {
+			rel, err := filepath.Rel(base, d)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				break
+			}
+			if _, err = os.Stat(d); err == nil {
+				break
+			}
+			dirs.Insert(filepath.ToSlash(rel))
+		}
+	}
+	return sets.List(dirs)
+}
+
+// snapshotWriter writes the files of a snapshot and its manifest.
+type snapshotWriter struct {
+	base     string
+	dir      string
+	snapshot Snapshot
+}
+
+// add copies the previous content of files into the snapshot and rewrites the manifest.
+func (w *snapshotWriter) add(states ...previousState) error {
+	for _, state := range states {
+		rel, err := filepath.Rel(w.base, state.path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			log.Warn("skip file outside base directory", "file", state.path)
+			continue
+		}
+		w.snapshot.Files = append(w.snapshot.Files, SnapshotFile{Path: filepath.ToSlash(rel), Existed: state.existed})
+		if !state.existed {
+			continue
+		}
+		target := filepath.Join(w.dir, snapshotFilesDir, rel)
+		if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			log.Error("failed to create directory", "directory", filepath.Dir(target), "cause", err)
+			return err
+		}
+		if err = os.WriteFile(target, state.content, state.mode); err != nil {
+			log.Error("failed to write file", "file", target, "cause", err)
+			return err
+		}
+	}
+
+	manifest, err := yaml.Marshal(&w.snapshot)
+	if err != nil {
+		return err
+	}
+	// the manifest is written last, as snapshots without a manifest are skipped
+	if err = writeFileAtomic(filepath.Join(w.dir, snapshotManifestFile), manifest, 0644); err != nil {
+		log.Error("failed to write file", "file", filepath.Join(w.dir, snapshotManifestFile), "cause", err)
+		return err
+	}
+	return nil
+}
+
+// discard removes the snapshot, e.g. when the regeneration is rolled back.
+func (w *snapshotWriter) discard() {
+	log.Info("remove snapshot", "directory", w.dir)
+	if err := os.RemoveAll(w.dir); err != nil {
+		log.Error("failed to remove directory", "directory", w.dir, "cause", err)
+	}
+}
+
+// ListSnapshots returns the snapshots in base directory, from oldest to newest.
+func ListSnapshots(base string) ([]Snapshot, error) {
+	root := filepath.Join(base, historyDir)
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error("failed to read directory", "directory", root, "cause", err)
+		return nil, err
+	}
+	snapshots := make([]Snapshot, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		b, err := os.ReadFile(filepath.Join(dir, snapshotManifestFile))
+		if err != nil {
+			log.Warn("skip invalid snapshot", "directory", dir, "cause", err)
+			continue
+		}
+		s := Snapshot{Name: e.Name()}
+		if err = yaml.Unmarshal(b, &s); err != nil {
+			log.Warn("skip invalid snapshot", "directory", dir, "cause", err)
+			continue
+		}
+		s.Config, _ = os.ReadFile(filepath.Join(dir, snapshotConfigFile))
+		snapshots = append(snapshots, s)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+	return snapshots, nil
+}
+
+func pruneSnapshots(base string, retain int) error {
+	snapshots, err := ListSnapshots(base)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(snapshots)-retain; i++ {
+		dir := filepath.Join(base, historyDir, snapshots[i].Name)
+		log.Info("remove old snapshot", "directory", dir)
+		if err = os.RemoveAll(dir); err != nil {
+			log.Error("failed to remove directory", "directory", dir, "cause", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Undo restores the files of the last snapshot and removes the snapshot.
+// Files and directories that did not exist before the regeneration are removed.
+func Undo(base string) error {
+	snapshots, err := ListSnapshots(base)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		log.Error("no snapshot to restore", "directory", filepath.Join(base, historyDir))
+		return errors.New("no snapshot to restore")
+	}
+	last := snapshots[len(snapshots)-1]
+	dir := filepath.Join(base, historyDir, last.Name)
+	log.Info("restore snapshot", "directory", dir)
+
+	tx := NewTransaction()
+	for _, f := range last.Files {
+		fp := filepath.Join(base, filepath.FromSlash(f.Path))
+		if !f.Existed {
+			log.Info("remove file", "file", fp)
+			tx.Remove(fp)
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, snapshotFilesDir, filepath.FromSlash(f.Path)))
+		if err != nil {
+			log.Error("failed to read snapshot file", "file", f.Path, "cause", err)
+			return err
+		}
+		log.Info("restore file", "file", fp)
+		tx.Add(fp, b)
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	// parents go after their children; directories that are not empty now are kept
+	for i := len(last.Dirs) - 1; i >= 0; i-- {
+		d := filepath.Join(base, filepath.FromSlash(last.Dirs[i]))
+		if err = os.Remove(d); err == nil {
+			log.Info("remove directory", "directory", d)
+		}
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		log.Error("failed to remove directory", "directory", dir, "cause", err)
+		return err
+	}
+	return nil
+}
+
+// PrintHistory writes the snapshots, from newest to oldest,
+// with the config diff that produced each one.
+func PrintHistory(w io.Writer, base string) error {
+	snapshots, err := ListSnapshots(base)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		fmt.Fprintln(w, "no snapshots")
+		return nil
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		s := snapshots[i]
+		fmt.Fprintf(w, "snapshot %s (%s, %d files)\n", s.Name, s.Time.Format(time.RFC3339), len(s.Files))
+		var prev []byte
+		if i > 0 {
+			prev = snapshots[i-1].Config
+		}
+		d := diffLines(prev, s.Config)
+		if len(d) == 0 {
+			fmt.Fprintln(w, "  config unchanged")
+		}
+		for _, line := range d {
+			fmt.Fprintln(w, "  "+line)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// diffLines returns the changed lines between a and b, prefixed with "-" or "+".
+func diffLines(a, b []byte) []string {
+	x, y := splitLines(a), splitLines(b)
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+x[i])
+			i++
+		default:
+			out = append(out, "+ "+y[j])
+			j++
+		}
+	}
+	return out
+}
+
+func splitLines(b []byte) []string {
+	b = bytes.TrimRight(b, NewLine)
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(string(b), NewLine)
+}
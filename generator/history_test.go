@@ -0,0 +1,188 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotAndUndo(t *testing.T) {
+	dir := t.TempDir()
+	before := map[string]string{
+		"main.go":  "old main",
+		"stale.go": "stale",
+		"go.sum":   "sum",
+	}
+	writeFiles(t, dir, before)
+	config := &Controller{Base: dir, History: HistoryConfig{Retain: 2}, source: []byte("name: Foo\n")}
+
+	tx := NewTransaction()
+	tx.Add(filepath.Join(dir, "main.go"), []byte("new main"))
+	tx.Add(filepath.Join(dir, "api", "v1", "types.go"), []byte("types"))
+	tx.Add(filepath.Join(dir, "same.go"), []byte("same"))
+	tx.Remove(filepath.Join(dir, "stale.go"))
+	tx.Track(filepath.Join(dir, "go.sum"))
+	writeFiles(t, dir, map[string]string{"same.go": "same"})
+	if err := SaveSnapshot(tx, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{"go.sum": "tidied"})
+
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("got %d snapshots, want 1", len(snapshots))
+	}
+	want := []SnapshotFile{
+		{Path: "main.go", Existed: true},
+		{Path: "api/v1/types.go", Existed: false},
+		{Path: "stale.go", Existed: true},
+		{Path: "go.sum", Existed: true},
+	}
+	if !reflect.DeepEqual(snapshots[0].Files, want) {
+		t.Errorf("files = %+v, want %+v", snapshots[0].Files, want)
+	}
+	if string(snapshots[0].Config) != "name: Foo\n" {
+		t.Errorf("config = %q", snapshots[0].Config)
+	}
+	if want := []string{"api", "api/v1"}; !reflect.DeepEqual(snapshots[0].Dirs, want) {
+		t.Errorf("dirs = %v, want %v", snapshots[0].Dirs, want)
+	}
+
+	if err = Undo(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, dir, before)
+	checkFiles(t, dir, map[string]string{"api/v1/types.go": ""})
+	if _, err = os.Stat(filepath.Join(dir, "api")); !os.IsNotExist(err) {
+		t.Errorf("directory created by the regeneration is not removed: %v", err)
+	}
+	if snapshots, _ = ListSnapshots(dir); len(snapshots) != 0 {
+		t.Errorf("snapshot is not removed by undo: %+v", snapshots)
+	}
+	if err = Undo(dir); err == nil {
+		t.Error("expected an error without snapshots")
+	}
+}
+
+func TestSnapshotSkipped(t *testing.T) {
+	tests := []struct {
+		name   string
+		retain int
+		files  map[string]string
+	}{
+		{name: "disabled", retain: 0, files: map[string]string{"main.go": "old"}},
+		{name: "no changes", retain: 10, files: map[string]string{"main.go": "new"}},
+		{name: "first run", retain: 10, files: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			tx := NewTransaction()
+			tx.Add(filepath.Join(dir, "main.go"), []byte("new"))
+			if err := SaveSnapshot(tx, &Controller{Base: dir, History: HistoryConfig{Retain: tt.retain}}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, historyDir)); !os.IsNotExist(err) {
+				t.Errorf("snapshot is saved: %v", err)
+			}
+		})
+	}
+}
+
+func TestSnapshotFailureWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	// the history directory cannot be created
+	writeFiles(t, dir, map[string]string{"main.go": "old", historyDir: "not a directory"})
+	tx := NewTransaction()
+	tx.Add(filepath.Join(dir, "main.go"), []byte("new"))
+	if err := SaveSnapshot(tx, &Controller{Base: dir, History: HistoryConfig{Retain: 10}}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if tx.snapshot != nil {
+		t.Error("failed snapshot is kept by the transaction")
+	}
+	checkFiles(t, dir, map[string]string{"main.go": "old"})
+}
+
+func TestRollbackDiscardsSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.go": "old", "user.go": "package main\nfunc  user() {}\n"})
+	tx := NewTransaction()
+	tx.Add(filepath.Join(dir, "main.go"), []byte("new"))
+	if err := SaveSnapshot(tx, &Controller{Base: dir, History: HistoryConfig{Retain: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// files rewritten after Commit are added to the snapshot before they are overwritten
+	if err := tx.Rewrite(filepath.Join(dir, "user.go"), []byte("package main\n\nfunc user() {}\n")); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := ListSnapshots(dir)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("ListSnapshots() = %+v, %v", snapshots, err)
+	}
+	want := []SnapshotFile{{Path: "main.go", Existed: true}, {Path: "user.go", Existed: true}}
+	if !reflect.DeepEqual(snapshots[0].Files, want) {
+		t.Errorf("files = %+v, want %+v", snapshots[0].Files, want)
+	}
+
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ = ListSnapshots(dir); len(snapshots) != 0 {
+		t.Errorf("snapshot of the rolled back transaction is kept: %+v", snapshots)
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"20240101-000000.000", "20240102-000000.000", "20240103-000000.000"} {
+		writeFiles(t, dir, map[string]string{filepath.Join(historyDir, name, snapshotManifestFile): "files: []\n"})
+	}
+	if err := pruneSnapshots(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range snapshots {
+		names = append(names, s.Name)
+	}
+	if want := []string{"20240102-000000.000", "20240103-000000.000"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("snapshots = %v, want %v", names, want)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{name: "equal", a: "a\nb\n", b: "a\nb\n", want: nil},
+		{name: "from nothing", a: "", b: "a\nb\n", want: []string{"+ a", "+ b"}},
+		{name: "to nothing", a: "a\n", b: "", want: []string{"- a"}},
+		{name: "changed line", a: "a\nb\nc\n", b: "a\nx\nc\n", want: []string{"- b", "+ x"}},
+		{name: "inserted line", a: "a\nc\n", b: "a\nb\nc\n", want: []string{"+ b"}},
+		{name: "trailing newlines are ignored", a: "a\n\n", b: "a", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines([]byte(tt.a), []byte(tt.b)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	msgInvalidResyncPeriod      = `resyncPeriod must be a non-negative go duration, e.g. 30s, 5m or 0 to disable resync`
+	msgInvalidLabelSelector     = `invalid labelSelector`
+	msgInvalidFieldSelector     = `invalid fieldSelector`
+	msgUnsupportedCustomField   = `field selectors of custom resources only support metadata.name and metadata.namespace; the informer may fail to list`
+	msgInvalidCacheMode         = `cache must be full or metadataOnly`
+	msgMetadataOnlyMainResource = `the main resource (the first one) cannot be cached as metadata only`
+)
+
+const (
+	defaultResyncPeriod      = "30s"
+	metadataInformerFileName = "metadata_informer.go"
+)
+
+// initResyncPeriod parses the resync period of r, which defaults to the resync period of the controller.
+// The informer of r reads it from flag "--<lowercase kind>-resync-period" if r has its own period,
+// or from flag "--resync-period" otherwise.
+func (c *Controller) initResyncPeriod(r *Resource) error {
+	if len(r.ResyncPeriod) == 0 {
+		r.resyncVar = "resyncPeriod"
+		return nil
+	}
+	d, err := parseResyncPeriod(r.ResyncPeriod)
+	if err != nil {
+		return err
+	}
+	r.resyncVar = r.LowerKind + "ResyncPeriod"
+	c.MainFlags = append(c.MainFlags,
+		"var "+r.resyncVar+" time.Duration",
+		"pflag.CommandLine.DurationVar(&"+r.resyncVar+`, "`+r.LowerKind+`-resync-period", `+durationLiteral(d)+`, "resync period of the `+r.LowerKind+` informer; 0 disables resync")`,
+	)
+	return nil
+}
+
+// initDefaultResyncPeriod parses the resync period of the controller and adds flag "--resync-period".
+func (c *Controller) initDefaultResyncPeriod() error {
+	if len(c.ResyncPeriod) == 0 {
+		c.ResyncPeriod = defaultResyncPeriod
+	}
+	d, err := parseResyncPeriod(c.ResyncPeriod)
+	if err != nil {
+		return err
+	}
+	c.MainFlags = append(c.MainFlags,
+		"var resyncPeriod time.Duration",
+		`pflag.CommandLine.DurationVar(&resyncPeriod, "resync-period", `+durationLiteral(d)+`, "resync period of informers without their own period; 0 disables resync")`,
+	)
+	return nil
+}
+
+// parseResyncPeriod parses a go duration like "30s" or "5m". "0" disables resync.
+func parseResyncPeriod(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.New(msgInvalidResyncPeriod)
+	}
+	return d, nil
+}
+
+// durationLiteral returns the go expression of d, e.g. "5*time.Minute".
+func durationLiteral(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "*time.Hour"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "*time.Minute"
+	case d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "*time.Second"
+	case d%time.Millisecond == 0:
+		return strconv.FormatInt(int64(d/time.Millisecond), 10) + "*time.Millisecond"
+	}
+	return "time.Duration(" + strconv.FormatInt(int64(d), 10) + ")"
+}
+
+// customResourceFields are the fields that field selectors of custom resources support.
+var customResourceFields = map[string]bool{
+	"metadata.name":      true,
+	"metadata.namespace": true,
+}
+
+// initSelectors checks the label selector and the field selector of r,
+// and decides the client that the informer of r lists and watches with.
+// It must be called after initCache.
+func (c *Controller) initSelectors(r *Resource) error {
+	r.informerClient = r.LowerKind + "Client"
+	if len(r.LabelSelector) == 0 && len(r.FieldSelector) == 0 {
+		return nil
+	}
+	if len(r.LabelSelector) > 0 {
+		sel, err := labels.Parse(r.LabelSelector)
+		if err != nil {
+			return fmt.Errorf("%s: %w", msgInvalidLabelSelector, err)
+		}
+		r.labelSelector = sel.String()
+	}
+	if len(r.FieldSelector) > 0 {
+		sel, err := fields.ParseSelector(r.FieldSelector)
+		if err != nil {
+			return fmt.Errorf("%s: %w", msgInvalidFieldSelector, err)
+		}
+		r.fieldSelector = sel.String()
+		if r.IsCustom {
+			for _, req := range sel.Requirements() {
+				if !customResourceFields[req.Field] {
+					log.Warn(msgUnsupportedCustomField, "resource", r.Kind, "field", req.Field)
+				}
+			}
+		}
+	}
+	// metadata informers take the selectors directly
+	if r.Cache == CacheMetadataOnly {
+		return nil
+	}
+	c.HasSelectors = true
+	r.informerClient = "newSelectorClient(" + r.LowerKind + "Client, " + strconv.Quote(r.labelSelector) + ", " + strconv.Quote(r.fieldSelector) + ")"
+	return nil
+}
+
+// initInformer adds the informer of r to the controller and to main.go.
+// It returns the lines of main.go that create the client and the informer of r.
+func (c *Controller) initInformer(r *Resource) (clientInits, informerInits []string) {
+	restClient := r.LowerKind + `Client := mustGetOrLogFatal(kool.NewRESTClient(config, httpClient, &schema.GroupVersion{Group: "` + r.SchemaGroup + `", Version: "` + r.Version + `"}))`
+	// init ns-based fields
+	switch {
+	case r.Cache == CacheMetadataOnly:
+		if r.IsCustom {
+			// custom resources are written by their REST clients
+			clientInits = append(clientInits, restClient)
+		}
+		informerInits = append(informerInits, c.initMetadataInformer(r))
+	case len(c.Namespace) > 0 && r.IsNamespaced:
+		c.ListerFields = append(c.ListerFields, r.LowerKind+"Lister kool.NamespacedLister["+r.GoType+"]")
+		clientInits = append(clientInits, restClient)
+		informerInits = append(informerInits, r.LowerKind+`Informer := kool.NewNamespacedInformer[`+r.GoType+`](`+r.informerClient+`, "`+c.Namespace+`", `+r.resyncVar+`)`)
+		c.NewControllerArgs = append(c.NewControllerArgs, r.LowerKind+`Informer kool.NamespacedInformer[`+r.GoType+`],`)
+	default:
+		c.ListerFields = append(c.ListerFields, r.LowerKind+"Lister kool.Lister["+r.GoType+"]")
+		clientInits = append(clientInits, restClient)
+		informerInits = append(informerInits, r.LowerKind+`Informer := kool.NewInformer[`+r.GoType+`](`+r.informerClient+`, `+r.resyncVar+`)`)
+		c.NewControllerArgs = append(c.NewControllerArgs, r.LowerKind+`Informer kool.Informer[`+r.GoType+`],`)
+	}
+	if transform := c.initTransform(r); len(transform) > 0 {
+		informerInits = append(informerInits, transform)
+	}
+	// init ns-independent fields
+	c.NewControllerCallArgs = append(c.NewControllerCallArgs, r.LowerKind+"Informer")
+	c.HasSyncedFields = append(c.HasSyncedFields, r.LowerKind+"Synced cache.InformerSynced")
+	c.StructFieldInits = append(c.StructFieldInits,
+		"c."+r.LowerKind+"Lister = "+r.LowerKind+"Informer.Lister()",
+		"c."+r.LowerKind+"Synced = "+r.LowerKind+"Informer.Informer().HasSynced",
+	)
+	c.InformerRuns = append(c.InformerRuns, "go "+r.LowerKind+"Informer.Informer().Run(ctx.Done())")
+	return clientInits, informerInits
+}
+
+// CacheMode is what the informer of a resource caches.
+type CacheMode string
+
+const (
+	// CacheFull caches whole objects of the go type of the resource.
+	CacheFull CacheMode = "full"
+	// CacheMetadataOnly caches the metadata of objects as *metav1.PartialObjectMetadata.
+	CacheMetadataOnly CacheMode = "metadataOnly"
+)
+
+// initCache checks the cache mode of r, and sets the type that the informer of r caches.
+// The main resource is always cached in full, as doSync usually reads its spec.
+// It must be called after the go type of r is initialized.
+func (c *Controller) initCache(r *Resource, isMain bool) error {
+	switch r.Cache {
+	case "":
+		r.Cache = CacheFull
+	case CacheFull:
+	case CacheMetadataOnly:
+		if isMain {
+			return errors.New(msgMetadataOnlyMainResource)
+		}
+		r.CachedType = "metav1.PartialObjectMetadata"
+		c.HasMetadataInformers = true
+		return nil
+	default:
+		return fmt.Errorf("%s: %q", msgInvalidCacheMode, r.Cache)
+	}
+	r.CachedType = r.GoType
+	return nil
+}
+
+// initMetadataInformer adds the metadata informer of r to the controller.
+// It is a MetadataInformer, or a NamespacedMetadataInformer if the controller watches a single namespace,
+// both of which are generated in metadata_informer.go.
+func (c *Controller) initMetadataInformer(r *Resource) string {
+	informerType, listerType, namespace := "MetadataInformer", "MetadataLister", ""
+	if len(c.Namespace) > 0 && r.IsNamespaced {
+		informerType, listerType, namespace = "NamespacedMetadataInformer", "NamespacedMetadataLister", strconv.Quote(c.Namespace)+", "
+	}
+	c.ListerFields = append(c.ListerFields, r.LowerKind+"Lister "+listerType)
+	c.NewControllerArgs = append(c.NewControllerArgs, r.LowerKind+"Informer *"+informerType+",")
+	gvr := `schema.GroupVersionResource{Group: "` + r.SchemaGroup + `", Version: "` + r.Version + `", Resource: "` + r.Plural + `"}`
+	return r.LowerKind + "Informer := New" + informerType + "(metadataClient, " + gvr + ", " + namespace + r.resyncVar + ", " +
+		strconv.Quote(r.labelSelector) + ", " + strconv.Quote(r.fieldSelector) + ")"
+}
+
+// initTransform adds the transform of the informer of r, which runs before objects are cached.
+func (c *Controller) initTransform(r *Resource) string {
+	if !r.StripManagedFields {
+		return ""
+	}
+	c.HasStripManagedFields = true
+	c.MainImports = append(c.MainImports, `"k8s.io/apimachinery/pkg/api/meta"`, `utilruntime "k8s.io/apimachinery/pkg/util/runtime"`)
+	return "utilruntime.Must(" + r.LowerKind + "Informer.Informer().SetTransform(stripManagedFields))"
+}
+
+// CreateOrRewriteMetadataInformer generates the informers and listers of metadata-only resources.
+// The file is removed if no resource is cached as metadata only.
+func CreateOrRewriteMetadataInformer(tx *Transaction, tmpl *template.Template, config *Controller) error {
+	fp := filepath.Join(config.Base, metadataInformerFileName)
+	if !config.HasMetadataInformers {
+		if isGeneratedByKoolbuilder(fp) {
+			log.Info("no metadata-only resource; remove file", "file", fp)
+			tx.Remove(fp)
+		}
+		return nil
+	}
+	log.Info("create or rewrite file", "file", metadataInformerFileName)
+	b, err := renderGo(tmpl, config)
+	if err != nil {
+		return err
+	}
+	tx.Add(fp, b)
+	return nil
+}
@@ -0,0 +1,377 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResyncPeriod(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "30s", want: 30 * time.Second},
+		{s: "1h30m", want: 90 * time.Minute},
+		{s: "0", want: 0},
+		{s: "-1s", wantErr: true},
+		{s: "30", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseResyncPeriod(tt.s)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseResyncPeriod(%q) = %v, %v, want %v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDurationLiteral(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 0, want: "0"},
+		{d: 2 * time.Hour, want: "2*time.Hour"},
+		{d: 90 * time.Minute, want: "90*time.Minute"},
+		{d: 30 * time.Second, want: "30*time.Second"},
+		{d: 1500 * time.Millisecond, want: "1500*time.Millisecond"},
+		{d: 1500, want: "time.Duration(1500)"},
+	}
+	for _, tt := range tests {
+		if got := durationLiteral(tt.d); got != tt.want {
+			t.Errorf("durationLiteral(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestInitResyncPeriod(t *testing.T) {
+	c := &Controller{ResyncPeriod: "5m"}
+	if err := c.initDefaultResyncPeriod(); err != nil {
+		t.Fatal(err)
+	}
+	own := &Resource{Kind: "Pod", LowerKind: "pod", ResyncPeriod: "0"}
+	inherited := &Resource{Kind: "Service", LowerKind: "service"}
+	for _, r := range []*Resource{own, inherited} {
+		if err := c.initResyncPeriod(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if own.resyncVar != "podResyncPeriod" || inherited.resyncVar != "resyncPeriod" {
+		t.Errorf("resync variables = %q, %q", own.resyncVar, inherited.resyncVar)
+	}
+	for _, want := range []string{
+		`pflag.CommandLine.DurationVar(&resyncPeriod, "resync-period", 5*time.Minute, "resync period of informers without their own period; 0 disables resync")`,
+		`pflag.CommandLine.DurationVar(&podResyncPeriod, "pod-resync-period", 0, "resync period of the pod informer; 0 disables resync")`,
+	} {
+		if !hasLine(c.MainFlags, want) {
+			t.Errorf("missing flag %q in %q", want, c.MainFlags)
+		}
+	}
+	if err := c.initResyncPeriod(&Resource{Kind: "Pod", ResyncPeriod: "1d"}); err == nil {
+		t.Error("initResyncPeriod() of an invalid period = nil, want error")
+	}
+}
+
+// resyncConfig sets the resync period of the controller and overrides it for pods.
+const resyncConfig = `name: Foo
+go:
+  module: example.com/generated
+resyncPeriod: 5m
+resources:
+- kind: Pod
+  isNamespaced: true
+  resyncPeriod: "0"
+- kind: ConfigMap
+  isNamespaced: true
+`
+
+func TestGeneratedResyncPeriod(t *testing.T) {
+	dir := generateController(t, resyncConfig, nil)
+	b, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"kool.NewInformer[corev1.Pod](podClient, podResyncPeriod)",
+		"kool.NewInformer[corev1.ConfigMap](configmapClient, resyncPeriod)",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("missing %q in main.go", want)
+		}
+	}
+	runGoTest(t, dir)
+}
+
+func TestInitSelectors(t *testing.T) {
+	tests := []struct {
+		name       string
+		r          Resource
+		wantClient string
+		wantErr    bool
+	}{
+		{
+			name:       "no selectors",
+			r:          Resource{Kind: "Pod", LowerKind: "pod"},
+			wantClient: "podClient",
+		},
+		{
+			name:       "label selector",
+			r:          Resource{Kind: "Pod", LowerKind: "pod", LabelSelector: "app in (a, b),tier"},
+			wantClient: `newSelectorClient(podClient, "app in (a,b),tier", "")`,
+		},
+		{
+			name:       "both selectors",
+			r:          Resource{Kind: "Secret", LowerKind: "secret", LabelSelector: "app=a", FieldSelector: "type=kubernetes.io/tls"},
+			wantClient: `newSelectorClient(secretClient, "app=a", "type=kubernetes.io/tls")`,
+		},
+		{
+			name:       "unsupported field of custom resource",
+			r:          Resource{Kind: "Foo", LowerKind: "foo", IsCustom: true, FieldSelector: "spec.size=1"},
+			wantClient: `newSelectorClient(fooClient, "", "spec.size=1")`,
+		},
+		{
+			name:    "invalid label selector",
+			r:       Resource{Kind: "Pod", LowerKind: "pod", LabelSelector: "app in (a"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid field selector",
+			r:       Resource{Kind: "Pod", LowerKind: "pod", FieldSelector: "status.phase"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{}
+			err := c.initSelectors(&tt.r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initSelectors() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.r.informerClient != tt.wantClient {
+				t.Errorf("informer client = %q, want %q", tt.r.informerClient, tt.wantClient)
+			}
+			if c.HasSelectors != (tt.wantClient != tt.r.LowerKind+"Client") {
+				t.Errorf("HasSelectors = %v", c.HasSelectors)
+			}
+		})
+	}
+}
+
+// selectorConfig lists and watches pods with selectors.
+const selectorConfig = `name: Foo
+go:
+  module: example.com/generated
+resources:
+- kind: Pod
+  isNamespaced: true
+  labelSelector: app=foo
+  fieldSelector: spec.nodeName=n1
+`
+
+// selectorTest sends requests with the selector client to a fake API server.
+const selectorTest = `package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+func TestSelectorClient(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client, err := rest.RESTClientFor(&rest.Config{
+		Host:    server.URL,
+		APIPath: "/api",
+		ContentConfig: rest.ContentConfig{
+			GroupVersion:         &schema.GroupVersion{Version: "v1"},
+			NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newSelectorClient(client, "app=foo", "spec.nodeName=n1")
+	ctx := context.Background()
+	c.Get().Resource("pods").Do(ctx)
+	c.Get().Resource("pods").Param("watch", "true").Do(ctx)
+	c.Post().Namespace("ns").Resource("pods").Body([]byte("{}")).Do(ctx)
+	for i, want := range []string{
+		"GET /api/v1/pods?fieldSelector=spec.nodeName%3Dn1&labelSelector=app%3Dfoo",
+		"GET /api/v1/pods?fieldSelector=spec.nodeName%3Dn1&labelSelector=app%3Dfoo&watch=true",
+		"POST /api/v1/namespaces/ns/pods",
+	} {
+		if i >= len(requests) || requests[i] != want {
+			t.Errorf("requests = %q, want request %d %q", requests, i, want)
+		}
+	}
+}
+`
+
+func TestGeneratedSelectorClient(t *testing.T) {
+	dir := generateController(t, selectorConfig, map[string]string{"selector_test.go": selectorTest})
+	b, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `kool.NewInformer[corev1.Pod](newSelectorClient(podClient, "app=foo", "spec.nodeName=n1"), resyncPeriod)`; !strings.Contains(string(b), want) {
+		t.Errorf("missing %q in main.go", want)
+	}
+	runGoTest(t, dir)
+}
+
+func TestInitCache(t *testing.T) {
+	tests := []struct {
+		name           string
+		r              Resource
+		isMain         bool
+		wantCachedType string
+		wantMetadata   bool
+		wantErr        bool
+	}{
+		{name: "default", r: Resource{GoType: "corev1.Pod"}, wantCachedType: "corev1.Pod"},
+		{name: "full", r: Resource{GoType: "corev1.Pod", Cache: CacheFull}, wantCachedType: "corev1.Pod"},
+		{name: "metadata only", r: Resource{GoType: "corev1.Pod", Cache: CacheMetadataOnly}, wantCachedType: "metav1.PartialObjectMetadata", wantMetadata: true},
+		{name: "metadata only main resource", r: Resource{GoType: "corev1.Pod", Cache: CacheMetadataOnly}, isMain: true, wantErr: true},
+		{name: "unknown mode", r: Resource{GoType: "corev1.Pod", Cache: "partial"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{}
+			err := c.initCache(&tt.r, tt.isMain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initCache() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.r.CachedType != tt.wantCachedType || c.HasMetadataInformers != tt.wantMetadata {
+				t.Errorf("cached type = %q, metadata informers = %v", tt.r.CachedType, c.HasMetadataInformers)
+			}
+		})
+	}
+}
+
+func TestInitMetadataInformer(t *testing.T) {
+	c := &Controller{Namespace: "ns"}
+	pod := &Resource{Kind: "Pod", LowerKind: "pod", Plural: "pods", Version: "v1", IsNamespaced: true, resyncVar: "resyncPeriod", labelSelector: "app=foo"}
+	node := &Resource{Kind: "Node", LowerKind: "node", Plural: "nodes", Version: "v1", resyncVar: "resyncPeriod"}
+	for r, want := range map[*Resource]string{
+		pod:  `podInformer := NewNamespacedMetadataInformer(metadataClient, schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}, "ns", resyncPeriod, "app=foo", "")`,
+		node: `nodeInformer := NewMetadataInformer(metadataClient, schema.GroupVersionResource{Group: "", Version: "v1", Resource: "nodes"}, resyncPeriod, "", "")`,
+	} {
+		if got := c.initMetadataInformer(r); got != want {
+			t.Errorf("initMetadataInformer() = %q, want %q", got, want)
+		}
+	}
+	for _, want := range []string{"podLister NamespacedMetadataLister", "nodeLister MetadataLister"} {
+		if !hasLine(c.ListerFields, want) {
+			t.Errorf("missing lister field %q in %q", want, c.ListerFields)
+		}
+	}
+}
+
+// metadataConfig caches pods in a namespace as metadata only, and strips managed fields of both resources.
+const metadataConfig = `name: Foo
+namespace: ns
+go:
+  module: example.com/generated
+resources:
+- kind: Node
+  stripManagedFields: true
+- kind: Pod
+  isNamespaced: true
+  cache: metadataOnly
+  labelSelector: app=foo
+  stripManagedFields: true
+`
+
+// metadataTest caches pods of a fake metadata client with the generated metadata informer.
+const metadataTest = `package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMetadataInformer(t *testing.T) {
+	scheme := fake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	pod := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns", Labels: map[string]string{"app": "foo"}},
+	}
+	client := fake.NewSimpleMetadataClient(scheme, pod)
+	informer := NewMetadataInformer(client, schema.GroupVersionResource{Version: "v1", Resource: "pods"}, 0, "app=foo", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go informer.Informer().Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.Informer().HasSynced) {
+		t.Fatal("cache is not synced")
+	}
+
+	got, err := informer.Lister().Namespaced("ns").Get("a")
+	if err != nil || got.Name != "a" {
+		t.Fatalf("Get() = %v, %v", got, err)
+	}
+	if _, err = informer.Lister().Namespaced("other").Get("a"); !errors.IsNotFound(err) {
+		t.Errorf("Get() of a missing pod error = %v, want not found", err)
+	}
+	if list, err := informer.Lister().List(labels.Everything()); err != nil || len(list) != 1 {
+		t.Errorf("List() = %v, %v", list, err)
+	}
+}
+
+func TestStripManagedFields(t *testing.T) {
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a", ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}}}
+	obj, err := stripManagedFields(cm)
+	if err != nil || obj != cm || cm.ManagedFields != nil {
+		t.Errorf("stripManagedFields() = %v, %v", obj, err)
+	}
+}
+`
+
+func TestGeneratedMetadataInformer(t *testing.T) {
+	dir := generateController(t, metadataConfig, map[string]string{"metadata_test.go": metadataTest})
+	b, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`podInformer := NewNamespacedMetadataInformer(metadataClient, schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}, "ns", resyncPeriod, "app=foo", "")`,
+		"utilruntime.Must(nodeInformer.Informer().SetTransform(stripManagedFields))",
+		"utilruntime.Must(podInformer.Informer().SetTransform(stripManagedFields))",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("missing %q in main.go", want)
+		}
+	}
+	runGoTest(t, dir)
+}
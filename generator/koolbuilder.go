@@ -3,13 +3,12 @@ package generator
 import (
 	"bytes"
 	"go/ast"
+	"go/format"
 	"go/parser"
-	"go/printer"
 	"go/token"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"text/template"
@@ -58,30 +57,119 @@ func retrieveControllerMethods(file *ast.File, controllerName string) sets.Set[s
 	return methods
 }
 
-func CreateOrRewriteGoMod(goModTmpl *template.Template, config *Controller) (err error) {
+// findControllerMethod returns the method of the controller in file, or nil if not found.
+func findControllerMethod(file *ast.File, controllerName, method string) *ast.FuncDecl {
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv == nil || funcDecl.Name.Name != method {
+			continue
+		}
+		starExpr, ok := funcDecl.Recv.List[0].Type.(*ast.StarExpr)
+		if !ok {
+			continue
+		}
+		if ident, ok := starExpr.X.(*ast.Ident); ok && ident.Name == controllerName {
+			return funcDecl
+		}
+	}
+	return nil
+}
+
+func CreateOrRewriteGoMod(tx *Transaction, goModTmpl *template.Template, config *Controller) (err error) {
 	log.Info("initializing go.mod")
 	fp := filepath.Join(config.Base, "go.mod")
-	_, err = os.Stat(fp)
-	if !os.IsNotExist(err) {
-		return
+	existing, err := os.ReadFile(fp)
+	if err == nil {
+		log.Info("merge existing go.mod", "file", fp)
+		b, changed, err := mergeGoMod(goModTmpl, config, existing)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			log.Info("go.mod is up to date")
+			return nil
+		}
+		tx.Add(fp, b)
+		return nil
 	}
-	f, err := os.Create(fp)
-	if err != nil {
-		log.Error("failed to write file", "file", fp, "cause", err)
+	if !os.IsNotExist(err) {
+		log.Error("failed to read file", "file", fp, "cause", err)
 		return
 	}
-	defer f.Close()
-	err = goModTmpl.Execute(f, config)
+	var buf bytes.Buffer
+	err = goModTmpl.Execute(&buf, config)
 	if err != nil {
 		log.Error("failed to execute template", "template", goModTmpl.Name(), "cause", err)
+		return
 	}
+	tx.Add(fp, buf.Bytes())
 	return
 }
 
-func CreateOrUpdateCustom(customTmpl *template.Template, config *Controller) (err error) {
+// addMissingImports returns src, the source of target, with the imports of cur that are missing in target.
+// Imports are inserted as text rather than into the AST of target,
+// since nodes of another file would move the comments of src around.
+func addMissingImports(fp string, src []byte, fset *token.FileSet, target, cur *ast.File) ([]byte, error) {
+	existedImports := retrieveImports(target)
+	var specs []string
+	for _, imp := range cur.Imports {
+		if existedImports.Has(imp.Path.Value) {
+			continue
+		}
+		log.Info("add new package to import list", "package", imp.Path.Value)
+		spec := imp.Path.Value
+		if imp.Name != nil {
+			spec = imp.Name.Name + " " + spec
+		}
+		specs = append(specs, Tab+spec+NewLine)
+	}
+	if len(specs) == 0 {
+		return src, nil
+	}
+
+	var g *ast.GenDecl
+	for _, decl := range target.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			g = d
+			break
+		}
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	var b bytes.Buffer
+	switch {
+	case g == nil:
+		// if there's no import declaration, create one after the package clause
+		log.Info("no import declaration found in existing file", "file", fp)
+		log.Info("create import block")
+		end := offset(target.Name.End())
+		b.Write(src[:end])
+		b.WriteString(NewLine + NewLine + "import (" + NewLine + strings.Join(specs, "") + ")")
+		b.Write(src[end:])
+	case g.Lparen.IsValid():
+		rparen := offset(g.Rparen)
+		b.Write(src[:rparen])
+		b.WriteString(NewLine + strings.Join(specs, ""))
+		b.Write(src[rparen:])
+	default:
+		// a single import without parentheses
+		imp := g.Specs[0].(*ast.ImportSpec)
+		start, end := offset(imp.Pos()), offset(imp.End())
+		if imp.Comment != nil {
+			end = offset(imp.Comment.End())
+		}
+		b.Write(src[:start])
+		b.WriteString("(" + NewLine + Tab)
+		b.Write(src[start:end])
+		b.WriteString(NewLine + strings.Join(specs, "") + ")")
+		b.Write(src[end:])
+	}
+	return format.Source(b.Bytes())
+}
+
+func CreateOrUpdateCustom(tx *Transaction, customTmpl *template.Template, config *Controller) (err error) {
 	fp := filepath.Join(config.Base, customTmpl.Name()+".go")
 	if _, err := os.Stat(fp); os.IsNotExist(err) {
-		return CreateOrRewrite(customTmpl, config)
+		return CreateOrRewrite(tx, customTmpl, config)
 	}
 	log.Info("update file", "file", customTmpl.Name()+".go")
 	f1, err := os.Open(fp)
@@ -103,52 +191,25 @@ func CreateOrUpdateCustom(customTmpl *template.Template, config *Controller) (er
 		return
 	}
 
-	buf.Reset()
-	customTmpl.Execute(&buf, config)
-	b2 := make([]byte, buf.Len())
-	copy(b2, buf.Bytes())
+	b2, err := renderGo(customTmpl, config)
+	if err != nil {
+		return
+	}
 	cur, err := parser.ParseFile(token.NewFileSet(), "", b2, parser.AllErrors|parser.ParseComments)
 	if err != nil {
 		log.Error("failed to parse AST from new template", "template", customTmpl.Name(), "cause", err)
+		return
 	}
 
-	// try to add missing imports
-	var g *ast.GenDecl
-	var hasImport bool
-	for _, decl := range target.Decls {
-		if g, hasImport = decl.(*ast.GenDecl); hasImport && g.Tok == token.IMPORT {
-			break
-		}
-	}
-
-	// if there's no import declaration, create one
-	if g == nil {
-		log.Info("no import declaration found in existing file", "file", fp)
-		g = &ast.GenDecl{
-			Tok: token.IMPORT,
-		}
-	}
-
-	existedImports := retrieveImports(target)
-	for _, imp := range cur.Imports {
-		if existedImports.Has(imp.Path.Value) {
-			continue
-		}
-		log.Info("add new package to import list", "package", imp.Path.Value)
-
-		g.Specs = append(g.Specs, imp)
-	}
-	if !hasImport && len(g.Specs) > 0 {
-		log.Info("create import block")
-		target.Decls = append([]ast.Decl{g}, target.Decls...)
+	src, err := addMissingImports(fp, b1, fset, target, cur)
+	if err != nil {
+		log.Error("failed to add imports", "file", fp, "cause", err)
+		return
 	}
 
 	// write to a temporary buffer, so we can add methods
 	var tmpBuf bytes.Buffer
-	printer.Fprint(&tmpBuf, fset, &printer.CommentedNode{
-		Node:     target,
-		Comments: target.Comments,
-	})
+	tmpBuf.Write(src)
 
 	existedMethods := retrieveControllerMethods(target, config.Name)
 	for _, decl := range cur.Decls {
@@ -175,63 +236,22 @@ func CreateOrUpdateCustom(customTmpl *template.Template, config *Controller) (er
 		return
 	}
 
-	f1, err = os.Create(fp)
-	if err != nil {
-		log.Error("failed to create file", "file", fp, "cause", err)
-	}
-	defer f1.Close()
 	log.Info("write to file", "file", fp)
-	tmpBuf.WriteTo(f1)
+	tx.Add(fp, tmpBuf.Bytes())
 	return
 }
 
-func CreateOrRewrite(tmpl *template.Template, config *Controller) (err error) {
+func CreateOrRewrite(tx *Transaction, tmpl *template.Template, config *Controller) (err error) {
 	log.Info("create or rewrite file", "file", tmpl.Name()+".go")
 	fp := filepath.Join(config.Base, tmpl.Name()+".go")
-	f, err := os.Create(fp)
+	b, err := renderGo(tmpl, config)
 	if err != nil {
-		log.Error("failed to write file", "file", fp, "cause", err)
 		return
 	}
-	defer f.Close()
-	err = tmpl.Execute(f, config)
-	if err != nil {
-		log.Error("failed to execute template", "template", tmpl.Name(), "cause", err)
-	}
+	tx.Add(fp, b)
 	return
 }
 
-func CreateOrRewriteDeepCopy(tmpl *template.Template, config *Controller) error {
-	for i := range config.Resources {
-		if !config.Resources[i].IsCustom || config.Resources[i].Template == TemplateNone {
-			continue
-		}
-
-		var fp string
-		if len(config.Resources[i].Package) == 0 {
-			fp = filepath.Join(config.Base, config.Resources[i].LowerKind+"_gen.deepcopy.go")
-		} else {
-			// filepath = basedir + (package - gomodule)
-			relativePath, err := filepath.Rel(config.Go.Module, config.Resources[i].Package)
-			if err != nil {
-				log.Error("failed to get relative path", "module", config.Go.Module, "package", config.Resources[i].Package, "cause", err)
-				return err
-			}
-			fp = filepath.Join(config.Base, relativePath, config.Resources[i].LowerKind+"_gen.deepcopy.go")
-		}
-		log.Info("write deepcopy", "resource", config.Resources[i].Kind, "file", fp)
-
-		f, err := os.Create(fp)
-		if err != nil {
-			log.Error("failed to create file", "file", fp, "cause", err)
-			return err
-		}
-		tmpl.Execute(f, &(config.Resources[i]))
-		f.Close()
-	}
-	return nil
-}
-
 func ReadConfig(filepath string) (*Controller, error) {
 	if strings.HasPrefix(filepath, "http://") || strings.HasPrefix(filepath, "https://") {
 		log.Info("fetching config file", "file", filepath)
@@ -254,23 +274,15 @@ func ReadConfig(filepath string) (*Controller, error) {
 }
 
 func ReadConfigFromReader(reader io.Reader) (*Controller, error) {
-	config := defaultController()
-	err := yaml.NewDecoder(reader).Decode(config)
+	source, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, err
 	}
-	return config, nil
-}
-
-func RunGoModTidy(config *Controller) error {
-	log.Info("run go mod tidy")
-	cmd := exec.Command("go", "mod", "tidy")
-	cmd.Dir = config.Base
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	config := defaultController()
+	err = yaml.NewDecoder(bytes.NewReader(source)).Decode(config)
 	if err != nil {
-		log.Error("failed to run go mod tidy", "cause", err)
+		return nil, err
 	}
-	return err
+	config.source = source
+	return config, nil
 }
@@ -0,0 +1,128 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	msgMapToPrimaryMainResource = `mapToPrimary is only supported by secondary resources`
+	msgNotOneMapping            = `a mapToPrimary rule must have exactly one of label, annotation and fieldPath`
+	msgInvalidMappingKey        = `invalid label or annotation key of mapToPrimary`
+	msgInvalidMappingFieldPath  = `fieldPath of mapToPrimary must be like .spec.configMapName`
+	msgMappingFieldPathNotFound = `fieldPath of mapToPrimary is not a string or a list of strings in the schema of the main resource`
+)
+
+// PrimaryMapping maps a secondary object to the main resources (the primaries) to enqueue.
+// Exactly one of the fields is set.
+//
+//	mapToPrimary:
+//	- label: example.com/foo        # the label value is the name of the primary
+//	- annotation: example.com/owner # the annotation value is "<namespace>/<name>" or "<name>" of the primary
+//	- fieldPath: .spec.configMapName # the field of the primary is the name of the secondary
+type PrimaryMapping struct {
+	Label      string `yaml:"label"`
+	Annotation string `yaml:"annotation"`
+	// FieldPath is a simple JSONPath of the primary, which is a string or a list of strings.
+	// Primaries are looked up by an informer index on the field.
+	FieldPath string `yaml:"fieldPath"`
+
+	// IndexName is the name of the index of FieldPath in the informer of the primary.
+	IndexName string `yaml:"-"`
+}
+
+// PrimaryIndexer is an index on a field of the primary.
+type PrimaryIndexer struct {
+	Name string
+	// Path is the fields of the path as go string literals, e.g. "spec", "configMapName".
+	Path string
+}
+
+var fieldPathRegex = regexp.MustCompile(`^(\.[A-Za-z_][A-Za-z0-9_]*)+$`)
+
+// initMapToPrimary checks the mapping rules of secondary resource r,
+// and adds an index to the primary for each field path rule.
+func (c *Controller) initMapToPrimary(r *Resource, isMain bool) error {
+	if len(r.MapToPrimary) == 0 {
+		return nil
+	}
+	if isMain {
+		return errors.New(msgMapToPrimaryMainResource)
+	}
+	for i := range r.MapToPrimary {
+		m := &(r.MapToPrimary[i])
+		set := 0
+		for _, v := range []string{m.Label, m.Annotation, m.FieldPath} {
+			if len(v) > 0 {
+				set++
+			}
+		}
+		if set != 1 {
+			return errors.New(msgNotOneMapping)
+		}
+		switch {
+		case len(m.Label) > 0:
+			if errs := validation.IsQualifiedName(m.Label); len(errs) > 0 {
+				return fmt.Errorf("%s: %q: %s", msgInvalidMappingKey, m.Label, strings.Join(errs, "; "))
+			}
+		case len(m.Annotation) > 0:
+			if errs := validation.IsQualifiedName(m.Annotation); len(errs) > 0 {
+				return fmt.Errorf("%s: %q: %s", msgInvalidMappingKey, m.Annotation, strings.Join(errs, "; "))
+			}
+		default:
+			if !fieldPathRegex.MatchString(m.FieldPath) {
+				return fmt.Errorf("%s: %q", msgInvalidMappingFieldPath, m.FieldPath)
+			}
+			m.IndexName = r.LowerKind + ":" + m.FieldPath
+			fields := strings.Split(strings.TrimPrefix(m.FieldPath, "."), ".")
+			for j := range fields {
+				fields[j] = strconv.Quote(fields[j])
+			}
+			c.PrimaryIndexers = append(c.PrimaryIndexers, PrimaryIndexer{Name: m.IndexName, Path: strings.Join(fields, ", ")})
+			// the field paths are checked against the schema of the primary when its CRD is generated
+			c.Resources[0].indexPaths = append(c.Resources[0].indexPaths, m.FieldPath)
+		}
+	}
+	c.HasMapToPrimary = true
+	c.ControllerImports = append(c.ControllerImports,
+		`"fmt"`,
+		`"k8s.io/apimachinery/pkg/api/meta"`,
+		`metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`,
+	)
+	return nil
+}
+
+// initPrimaryIndexers adds the indexer of the primary to the controller if any field path rule exists.
+// It must be called after the mapping rules of all resources are initialized.
+func (c *Controller) initPrimaryIndexers() {
+	if len(c.PrimaryIndexers) == 0 {
+		return
+	}
+	main := c.Resources[0].LowerKind
+	c.ListerFields = append(c.ListerFields, main+"Indexer cache.Indexer")
+	c.StructFieldInits = append(c.StructFieldInits, "c."+main+"Indexer = "+main+"Informer.Informer().GetIndexer()")
+	c.ControllerImports = append(c.ControllerImports,
+		`"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"`,
+		`"k8s.io/apimachinery/pkg/runtime"`,
+	)
+}
+
+// checkIndexPaths warns about the field paths of mapping rules that are not strings or lists of strings
+// in schema of the primary.
+func (r *Resource) checkIndexPaths(schema *JSONSchema) {
+	for _, path := range r.indexPaths {
+		found := schema.lookup(path)
+		if found != nil && found.Type == "array" {
+			found = found.Items
+		}
+		if found == nil || found.Type != "string" {
+			log.Warn(msgMappingFieldPathNotFound, "resource", r.Kind, "fieldPath", path)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitMapToPrimary(t *testing.T) {
+	tests := []struct {
+		name        string
+		mappings    []PrimaryMapping
+		isMain      bool
+		wantIndexer []PrimaryIndexer
+		wantErr     bool
+	}{
+		{name: "no rules"},
+		{name: "label", mappings: []PrimaryMapping{{Label: "example.com/foo"}}},
+		{name: "annotation", mappings: []PrimaryMapping{{Annotation: "example.com/owner"}}},
+		{
+			name:        "field paths",
+			mappings:    []PrimaryMapping{{FieldPath: ".spec.configMapName"}, {FieldPath: ".spec.volumes"}},
+			wantIndexer: []PrimaryIndexer{{Name: "configmap:.spec.configMapName", Path: `"spec", "configMapName"`}, {Name: "configmap:.spec.volumes", Path: `"spec", "volumes"`}},
+		},
+		{name: "main resource", mappings: []PrimaryMapping{{Label: "example.com/foo"}}, isMain: true, wantErr: true},
+		{name: "no field", mappings: []PrimaryMapping{{}}, wantErr: true},
+		{name: "two fields", mappings: []PrimaryMapping{{Label: "example.com/foo", Annotation: "example.com/foo"}}, wantErr: true},
+		{name: "invalid label", mappings: []PrimaryMapping{{Label: "example.com/foo/bar"}}, wantErr: true},
+		{name: "invalid annotation", mappings: []PrimaryMapping{{Annotation: "-foo"}}, wantErr: true},
+		{name: "field path without dot", mappings: []PrimaryMapping{{FieldPath: "spec.configMapName"}}, wantErr: true},
+		{name: "field path with index", mappings: []PrimaryMapping{{FieldPath: ".spec.volumes[0]"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{Resources: []Resource{{Kind: "Foo", LowerKind: "foo"}, {Kind: "ConfigMap", LowerKind: "configmap", MapToPrimary: tt.mappings}}}
+			err := c.initMapToPrimary(&(c.Resources[1]), tt.isMain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initMapToPrimary() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c.HasMapToPrimary != (len(tt.mappings) > 0) {
+				t.Errorf("HasMapToPrimary = %v", c.HasMapToPrimary)
+			}
+			if len(c.PrimaryIndexers) != len(tt.wantIndexer) {
+				t.Fatalf("indexers = %+v, want %+v", c.PrimaryIndexers, tt.wantIndexer)
+			}
+			for i := range tt.wantIndexer {
+				if c.PrimaryIndexers[i] != tt.wantIndexer[i] {
+					t.Errorf("indexers = %+v, want %+v", c.PrimaryIndexers, tt.wantIndexer)
+				}
+				if c.Resources[1].MapToPrimary[i].IndexName != tt.wantIndexer[i].Name {
+					t.Errorf("index name = %q, want %q", c.Resources[1].MapToPrimary[i].IndexName, tt.wantIndexer[i].Name)
+				}
+			}
+			if len(c.Resources[0].indexPaths) != len(tt.wantIndexer) {
+				t.Errorf("index paths of the main resource = %q", c.Resources[0].indexPaths)
+			}
+		})
+	}
+}
+
+func TestInitPrimaryIndexers(t *testing.T) {
+	c := &Controller{Resources: []Resource{{Kind: "Foo", LowerKind: "foo"}}}
+	c.initPrimaryIndexers()
+	if len(c.ListerFields) > 0 || len(c.StructFieldInits) > 0 {
+		t.Errorf("fields without indexers = %q, %q", c.ListerFields, c.StructFieldInits)
+	}
+	c.PrimaryIndexers = []PrimaryIndexer{{Name: "configmap:.spec.configMapName", Path: `"spec", "configMapName"`}}
+	c.initPrimaryIndexers()
+	if !hasLine(c.ListerFields, "fooIndexer cache.Indexer") || !hasLine(c.StructFieldInits, "c.fooIndexer = fooInformer.Informer().GetIndexer()") {
+		t.Errorf("fields = %q, %q", c.ListerFields, c.StructFieldInits)
+	}
+}
+
+// mappingConfig maps config maps to pods by a label or an annotation,
+// and service accounts to pods by an indexed field of pods.
+const mappingConfig = `name: Foo
+go:
+  module: example.com/generated
+resources:
+- kind: Pod
+  isNamespaced: true
+- kind: ConfigMap
+  isNamespaced: true
+  mapToPrimary:
+  - label: example.com/pod
+  - annotation: example.com/owner
+- kind: ServiceAccount
+  isNamespaced: true
+  mapToPrimary:
+  - fieldPath: .spec.serviceAccountName
+`
+
+// mappingTest maps secondary objects to the keys of pods in an indexer.
+const mappingTest = `package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMapToPrimaries(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		"serviceaccount:.spec.serviceAccountName": fieldIndexFunc("spec", "serviceAccountName"),
+	})
+	for _, pod := range []*corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "ns"}, Spec: corev1.PodSpec{ServiceAccountName: "sa"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "ns"}, Spec: corev1.PodSpec{ServiceAccountName: "sa"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "other"}, Spec: corev1.PodSpec{ServiceAccountName: "sa"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "d", Namespace: "ns"}},
+	} {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &Foo{podIndexer: indexer}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "sa", Namespace: "ns"}}
+	if got, want := c.mapServiceAccountToPrimaries(sa), []string{"ns/a", "ns/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mapServiceAccountToPrimaries() = %q, want %q", got, want)
+	}
+	for annotation, want := range map[string][]string{
+		"":        {"ns/a"},
+		"b":       {"ns/a", "ns/b"},
+		"other/c": {"ns/a", "other/c"},
+		"a/b/c":   {"ns/a"},
+	} {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:        "cm",
+			Namespace:   "ns",
+			Labels:      map[string]string{"example.com/pod": "a"},
+			Annotations: map[string]string{"example.com/owner": annotation},
+		}}
+		if got := c.mapConfigMapToPrimaries(cm); !reflect.DeepEqual(got, want) {
+			t.Errorf("mapConfigMapToPrimaries() with annotation %q = %q, want %q", annotation, got, want)
+		}
+	}
+}
+
+func TestFieldIndexFunc(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"metadata": map[string]any{"name": "a", "namespace": "ns"},
+		"spec":     map[string]any{"names": []any{"x", "y", 1}, "name": "z", "count": int64(1)},
+	}}
+	for path, want := range map[string][]string{
+		"names":   {"ns/x", "ns/y"},
+		"name":    {"ns/z"},
+		"count":   nil,
+		"missing": nil,
+	} {
+		got, err := fieldIndexFunc("spec", path)(obj)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("index of %s = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
+`
+
+func TestGeneratedMapToPrimary(t *testing.T) {
+	dir := generateController(t, mappingConfig, map[string]string{"mapping_test.go": mappingTest})
+	b, err := os.ReadFile(filepath.Join(dir, "controller.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"serviceaccount:.spec.serviceAccountName": fieldIndexFunc("spec", "serviceAccountName"),`,
+		"configmapInformer.Informer().AddEventHandler(c.mapperEventHandler(c.mapConfigMapToPrimaries))",
+		"serviceaccountInformer.Informer().AddEventHandler(c.mapperEventHandler(c.mapServiceAccountToPrimaries))",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("missing %q in controller.go", want)
+		}
+	}
+	runGoTest(t, dir)
+}
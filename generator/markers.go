@@ -0,0 +1,221 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+)
+
+const (
+	msgUnsupportedMarker   = `unsupported validation marker is ignored`
+	msgInvalidMarker       = `invalid validation marker`
+	msgRequiredAndOptional = `field cannot be both required and optional`
+	msgInvalidDefault      = `default value does not satisfy the validation markers`
+)
+
+const validationMarkerPrefix = "+kubebuilder:validation:"
+
+// Markers are the validation markers in the doc comment of a type or a field.
+//
+//	// +kubebuilder:validation:Minimum=1
+//	// +kubebuilder:validation:Maximum=10
+//	// +kubebuilder:validation:Pattern=`^[a-z]+$`
+//	// +kubebuilder:validation:Enum=Always;Never
+//	// +kubebuilder:validation:MaxItems=3
+//	// +kubebuilder:validation:Format=email
+//	// +kubebuilder:validation:Required
+//	// +kubebuilder:validation:Optional (or +optional)
+//	// +default=3 (or +kubebuilder:default=3)
+type Markers struct {
+	Minimum  *float64
+	Maximum  *float64
+	Pattern  string
+	Enum     []any
+	MaxItems *int64
+	Format   string
+
+	Required bool
+	Optional bool
+
+	HasDefault bool
+	Default    any
+}
+
+// parseMarkers parses the validation markers in doc.
+func parseMarkers(doc *ast.CommentGroup) (Markers, error) {
+	var m Markers
+	if doc == nil {
+		return m, nil
+	}
+	for _, c := range doc.List {
+		line := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(c.Text, "//"), "/*"))
+		if !strings.HasPrefix(line, "+") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, "=")
+		var err error
+		switch name {
+		case "+optional", validationMarkerPrefix + "Optional":
+			m.Optional = true
+		case "+required", validationMarkerPrefix + "Required":
+			m.Required = true
+		case "+default", "+kubebuilder:default":
+			m.HasDefault, m.Default = true, parseMarkerValue(value)
+		case validationMarkerPrefix + "Minimum":
+			m.Minimum, err = parseMarkerFloat(value)
+		case validationMarkerPrefix + "Maximum":
+			m.Maximum, err = parseMarkerFloat(value)
+		case validationMarkerPrefix + "MaxItems":
+			var n int64
+			n, err = strconv.ParseInt(value, 10, 64)
+			m.MaxItems = &n
+		case validationMarkerPrefix + "Pattern":
+			m.Pattern = unquoteMarkerValue(value)
+			_, err = regexp.Compile(m.Pattern)
+		case validationMarkerPrefix + "Enum":
+			for _, v := range strings.Split(value, ";") {
+				m.Enum = append(m.Enum, parseMarkerValue(v))
+			}
+		case validationMarkerPrefix + "Format":
+			m.Format = unquoteMarkerValue(value)
+		default:
+			if strings.HasPrefix(name, validationMarkerPrefix) {
+				log.Warn(msgUnsupportedMarker, "marker", line)
+			}
+			continue
+		}
+		if err != nil {
+			return m, fmt.Errorf("%s %s: %w", msgInvalidMarker, line, err)
+		}
+	}
+	if m.Required && m.Optional {
+		return m, errors.New(msgRequiredAndOptional)
+	}
+	if m.HasDefault {
+		if err := m.check(m.Default); err != nil {
+			return m, fmt.Errorf("%s: %w", msgInvalidDefault, err)
+		}
+	}
+	return m, nil
+}
+
+func parseMarkerFloat(value string) (*float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	return &f, err
+}
+
+// parseMarkerValue parses a JSON value, or returns the raw string if value is not JSON.
+func parseMarkerValue(value string) any {
+	value = strings.TrimSpace(value)
+	var v any
+	if err := json.Unmarshal([]byte(value), &v); err == nil {
+		return v
+	}
+	return unquoteMarkerValue(value)
+}
+
+// unquoteMarkerValue removes the backquotes or double quotes around value.
+func unquoteMarkerValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '`' && value[len(value)-1] == '`' {
+		return value[1 : len(value)-1]
+	}
+	if s, err := strconv.Unquote(value); err == nil {
+		return s
+	}
+	return value
+}
+
+// merge returns m with the markers of other added. Markers of other take precedence.
+func (m Markers) merge(other Markers) Markers {
+	if other.Minimum != nil {
+		m.Minimum = other.Minimum
+	}
+	if other.Maximum != nil {
+		m.Maximum = other.Maximum
+	}
+	if len(other.Pattern) > 0 {
+		m.Pattern = other.Pattern
+	}
+	if other.Enum != nil {
+		m.Enum = other.Enum
+	}
+	if other.MaxItems != nil {
+		m.MaxItems = other.MaxItems
+	}
+	if len(other.Format) > 0 {
+		m.Format = other.Format
+	}
+	if other.HasDefault {
+		m.HasDefault, m.Default = true, other.Default
+	}
+	m.Required, m.Optional = other.Required, other.Optional
+	return m
+}
+
+// hasChecks reports whether m needs any check of the value.
+func (m Markers) hasChecks() bool {
+	return m.Minimum != nil || m.Maximum != nil || len(m.Pattern) > 0 || m.Enum != nil || m.MaxItems != nil ||
+		len(m.Format) > 0 && formatChecks[m.Format] != nil
+}
+
+// check checks a JSON value against m, e.g. a default value at generation time.
+func (m Markers) check(v any) error {
+	switch v := v.(type) {
+	case float64:
+		if m.Minimum != nil && v < *m.Minimum {
+			return fmt.Errorf("%v is less than minimum %v", v, *m.Minimum)
+		}
+		if m.Maximum != nil && v > *m.Maximum {
+			return fmt.Errorf("%v is greater than maximum %v", v, *m.Maximum)
+		}
+	case string:
+		if len(m.Pattern) > 0 && !regexp.MustCompile(m.Pattern).MatchString(v) {
+			return fmt.Errorf("%q does not match pattern %s", v, m.Pattern)
+		}
+	case []any:
+		if m.MaxItems != nil && int64(len(v)) > *m.MaxItems {
+			return fmt.Errorf("%d items are more than max items %d", len(v), *m.MaxItems)
+		}
+	}
+	if m.Enum != nil {
+		for _, e := range m.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is not one of %v", v, m.Enum)
+	}
+	return nil
+}
+
+// apply adds the markers to the schema s.
+func (m Markers) apply(s *JSONSchema) {
+	if m.Minimum != nil {
+		s.Minimum = m.Minimum
+	}
+	if m.Maximum != nil {
+		s.Maximum = m.Maximum
+	}
+	if m.MaxItems != nil {
+		s.MaxItems = m.MaxItems
+	}
+	if len(m.Pattern) > 0 {
+		s.Pattern = m.Pattern
+	}
+	if m.Enum != nil {
+		s.Enum = m.Enum
+	}
+	if len(m.Format) > 0 {
+		s.Format = m.Format
+	}
+	if m.HasDefault {
+		s.Default = m.Default
+	}
+}
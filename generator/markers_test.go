@@ -0,0 +1,175 @@
+package generator
+
+import (
+	"go/ast"
+	"reflect"
+	"testing"
+)
+
+// commentGroup returns a doc comment of lines, each without "//".
+func commentGroup(lines ...string) *ast.CommentGroup {
+	doc := &ast.CommentGroup{}
+	for _, line := range lines {
+		doc.List = append(doc.List, &ast.Comment{Text: "//" + line})
+	}
+	return doc
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestParseMarkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     []string
+		want    Markers
+		wantErr bool
+	}{
+		{
+			name: "no markers",
+			doc:  []string{" Replicas is the number of pods.", " It is not a +marker."},
+			want: Markers{},
+		},
+		{
+			name: "numbers and items",
+			doc: []string{
+				" +kubebuilder:validation:Minimum=1",
+				" +kubebuilder:validation:Maximum=10.5",
+				" +kubebuilder:validation:MaxItems=3",
+			},
+			want: Markers{Minimum: ptr(1.0), Maximum: ptr(10.5), MaxItems: ptr(int64(3))},
+		},
+		{
+			name: "pattern and format",
+			doc: []string{
+				" +kubebuilder:validation:Pattern=`^[a-z]+=[0-9]+$`",
+				` +kubebuilder:validation:Format="email"`,
+			},
+			want: Markers{Pattern: "^[a-z]+=[0-9]+$", Format: "email"},
+		},
+		{
+			name: "enum of strings and numbers",
+			doc:  []string{" +kubebuilder:validation:Enum=Always;Never;3"},
+			want: Markers{Enum: []any{"Always", "Never", 3.0}},
+		},
+		{
+			name: "required and optional",
+			doc:  []string{" +required"},
+			want: Markers{Required: true},
+		},
+		{
+			name: "optional with kubebuilder prefix",
+			doc:  []string{" +kubebuilder:validation:Optional"},
+			want: Markers{Optional: true},
+		},
+		{
+			name: "default number",
+			doc:  []string{" +kubebuilder:validation:Minimum=1", " +default=3"},
+			want: Markers{Minimum: ptr(1.0), HasDefault: true, Default: 3.0},
+		},
+		{
+			name: "default string without quotes",
+			doc:  []string{" +kubebuilder:default=Always", " +kubebuilder:validation:Enum=Always;Never"},
+			want: Markers{Enum: []any{"Always", "Never"}, HasDefault: true, Default: "Always"},
+		},
+		{
+			name: "default object",
+			doc:  []string{` +default={"a":"b"}`},
+			want: Markers{HasDefault: true, Default: map[string]any{"a": "b"}},
+		},
+		{
+			name: "unsupported marker is ignored",
+			doc:  []string{" +kubebuilder:validation:MinLength=1"},
+			want: Markers{},
+		},
+		{
+			name:    "invalid minimum",
+			doc:     []string{" +kubebuilder:validation:Minimum=one"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid max items",
+			doc:     []string{" +kubebuilder:validation:MaxItems=1.5"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid pattern",
+			doc:     []string{" +kubebuilder:validation:Pattern=`[a-z`"},
+			wantErr: true,
+		},
+		{
+			name:    "required and optional",
+			doc:     []string{" +required", " +optional"},
+			wantErr: true,
+		},
+		{
+			name:    "default less than minimum",
+			doc:     []string{" +kubebuilder:validation:Minimum=1", " +default=0"},
+			wantErr: true,
+		},
+		{
+			name:    "default greater than maximum",
+			doc:     []string{" +kubebuilder:validation:Maximum=1", " +default=2"},
+			wantErr: true,
+		},
+		{
+			name:    "default not matching pattern",
+			doc:     []string{" +kubebuilder:validation:Pattern=`^[a-z]+$`", ` +default="ABC"`},
+			wantErr: true,
+		},
+		{
+			name:    "default not in enum",
+			doc:     []string{" +kubebuilder:validation:Enum=Always;Never", " +default=Sometimes"},
+			wantErr: true,
+		},
+		{
+			name:    "default with too many items",
+			doc:     []string{" +kubebuilder:validation:MaxItems=1", ` +default=["a","b"]`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMarkers(commentGroup(tt.doc...))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMarkers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMarkersNil(t *testing.T) {
+	m, err := parseMarkers(nil)
+	if err != nil || !reflect.DeepEqual(m, Markers{}) {
+		t.Errorf("parseMarkers(nil) = %+v, %v", m, err)
+	}
+}
+
+func TestMarkersMerge(t *testing.T) {
+	typ := Markers{Minimum: ptr(1.0), Pattern: "^a", HasDefault: true, Default: "a", Required: true}
+	field := Markers{Minimum: ptr(2.0), Format: "email", Optional: true}
+	want := Markers{Minimum: ptr(2.0), Pattern: "^a", Format: "email", HasDefault: true, Default: "a", Optional: true}
+	if got := typ.merge(field); !reflect.DeepEqual(got, want) {
+		t.Errorf("merge() = %+v, want %+v", got, want)
+	}
+}
+
+func TestMarkersApply(t *testing.T) {
+	m := Markers{Minimum: ptr(1.0), Maximum: ptr(3.0), Pattern: "^a", Enum: []any{"a"}, MaxItems: ptr(int64(2)), Format: "email", HasDefault: true, Default: "a"}
+	s := &JSONSchema{Type: "string"}
+	m.apply(s)
+	want := &JSONSchema{Type: "string", Minimum: ptr(1.0), Maximum: ptr(3.0), Pattern: "^a", Enum: []any{"a"}, MaxItems: ptr(int64(2)), Format: "email", Default: "a"}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("apply() = %+v, want %+v", s, want)
+	}
+}
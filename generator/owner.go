@@ -0,0 +1,24 @@
+package generator
+
+import "errors"
+
+const msgEnqueueOwnerMainResource = `enqueueOwner is only supported by secondary resources`
+
+// initEnqueueOwner checks enqueueOwner of r. The main resource cannot enqueue its owner,
+// since the queue only has keys of the main resource.
+func (c *Controller) initEnqueueOwner(r *Resource, isMain bool) error {
+	if !r.EnqueueOwner {
+		return nil
+	}
+	if isMain {
+		return errors.New(msgEnqueueOwnerMainResource)
+	}
+	c.HasEnqueueOwner = true
+	c.ControllerImports = append(c.ControllerImports,
+		`"fmt"`,
+		`"k8s.io/apimachinery/pkg/api/meta"`,
+		`metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`,
+		`"k8s.io/apimachinery/pkg/runtime/schema"`,
+	)
+	return nil
+}
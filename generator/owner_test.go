@@ -0,0 +1,102 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitEnqueueOwner(t *testing.T) {
+	c := &Controller{}
+	if err := c.initEnqueueOwner(&Resource{Kind: "Pod"}, false); err != nil || c.HasEnqueueOwner {
+		t.Errorf("initEnqueueOwner() without enqueueOwner = %v, HasEnqueueOwner %v", err, c.HasEnqueueOwner)
+	}
+	if err := c.initEnqueueOwner(&Resource{Kind: "Deployment", EnqueueOwner: true}, true); err == nil {
+		t.Error("initEnqueueOwner() of the main resource = nil, want error")
+	}
+	if err := c.initEnqueueOwner(&Resource{Kind: "ReplicaSet", EnqueueOwner: true}, false); err != nil || !c.HasEnqueueOwner {
+		t.Errorf("initEnqueueOwner() = %v, HasEnqueueOwner %v", err, c.HasEnqueueOwner)
+	}
+	if !hasLine(c.ControllerImports, `metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`) {
+		t.Errorf("controller imports = %q", c.ControllerImports)
+	}
+}
+
+// ownerConfig controls deployments, and enqueues the deployment of a replica set.
+const ownerConfig = `name: Foo
+go:
+  module: example.com/generated
+resources:
+- kind: Deployment
+  package: k8s.io/api/apps/v1
+  isNamespaced: true
+- kind: ReplicaSet
+  package: k8s.io/api/apps/v1
+  isNamespaced: true
+  enqueueOwner: true
+`
+
+// ownerTest sends events of replica sets to the event handler of their owners.
+const ownerTest = `package main
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func replicaSet(resourceVersion, apiVersion, owner string, controller bool) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:            "rs",
+		Namespace:       "ns",
+		ResourceVersion: resourceVersion,
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: apiVersion, Kind: "Deployment", Name: owner, Controller: &controller}},
+	}}
+}
+
+func TestOwnerKeys(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	c := &Foo{queue: queue, failures: make(map[any]int)}
+	h := c.mapperEventHandler(c.ownerKeys)
+
+	h.AddFunc(replicaSet("1", "apps/v1", "a", true))
+	h.AddFunc(replicaSet("1", "apps/v1", "b", false))
+	h.AddFunc(replicaSet("1", "example.com/v1", "c", true))
+	h.UpdateFunc(replicaSet("1", "apps/v1", "d", true), replicaSet("1", "apps/v1", "d", true))
+	h.UpdateFunc(replicaSet("1", "apps/v1", "e", true), replicaSet("2", "apps/v1", "f", true))
+	h.DeleteFunc(cache.DeletedFinalStateUnknown{Key: "ns/rs", Obj: replicaSet("2", "apps/v1", "g", true)})
+
+	var keys []any
+	for queue.Len() > 0 {
+		key, _ := queue.Get()
+		keys = append(keys, key)
+		queue.Done(key)
+	}
+	want := []any{"ns/a", "ns/e", "ns/f", "ns/g"}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("keys = %v, want %v", keys, want)
+		}
+	}
+}
+`
+
+func TestGeneratedOwnerKeys(t *testing.T) {
+	dir := generateController(t, ownerConfig, map[string]string{"owner_test.go": ownerTest})
+	b, err := os.ReadFile(filepath.Join(dir, "controller.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "replicasetInformer.Informer().AddEventHandler(c.mapperEventHandler(c.ownerKeys))"; !strings.Contains(string(b), want) {
+		t.Errorf("missing %q in controller.go", want)
+	}
+	runGoTest(t, dir)
+}
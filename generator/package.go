@@ -0,0 +1,181 @@
+package generator
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	msgPackageOutsideModule    = `package of generated resource template is outside the go module`
+	msgPackageOutsideModuleTip = `set package to the go module or one of its subpackages (e.g. <module>/api/v1), or set template to 0 for a resource defined outside the module`
+)
+
+// generatedFileSuffix is the suffix of deepcopy files generated into resource packages.
+const generatedFileSuffix = "_gen.deepcopy.go"
+
+// isGeneratedFile reports whether name is a file generated into resource packages.
+// Generated files are skipped when reading a package, as they may be generated by an older version.
+func isGeneratedFile(name string) bool {
+	return strings.HasSuffix(name, generatedFileSuffix) || strings.HasSuffix(name, validateFileSuffix)
+}
+
+// initResourcePackage sets the directory and the go package name of a custom resource
+// whose template is generated by koolbuilder.
+//
+// The package must be the module itself or one of its subpackages.
+func initResourcePackage(r *Resource, base, module string) error {
+	if len(r.Package) == 0 || r.Package == module {
+		r.Dir, r.GoPackage = base, "main"
+		return nil
+	}
+	if !strings.HasPrefix(r.Package, module+"/") {
+		log.Error(msgConfigInvalid,
+			"cause", msgPackageOutsideModule,
+			"kind", r.Kind,
+			"package", r.Package,
+			"module", module,
+			"tip", msgPackageOutsideModuleTip,
+		)
+		return errors.New(msgPackageOutsideModule)
+	}
+	r.Dir = filepath.Join(base, filepath.FromSlash(strings.TrimPrefix(r.Package, module+"/")))
+	name, found, err := packageNameInDir(r.Dir)
+	if err != nil {
+		return err
+	}
+	if !found {
+		name = packageNameFromPath(r.Package)
+	}
+	r.GoPackage = name
+	return nil
+}
+
+// packageNameInDir returns the package name declared by existing go files in dir.
+func packageNameInDir(dir string) (string, bool, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		log.Error("failed to read directory", "directory", dir, "cause", err)
+		return "", false, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || isGeneratedFile(name) {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err != nil {
+			log.Warn("failed to parse package clause", "file", filepath.Join(dir, name), "cause", err)
+			continue
+		}
+		return f.Name.Name, true, nil
+	}
+	return "", false, nil
+}
+
+// packageNameFromPath derives a go package name from the last element of an import path.
+//
+//	mymodule/api/v1      -> v1
+//	mymodule/my-api.io   -> my_api_io
+func packageNameFromPath(pkg string) string {
+	name := strings.ToLower(path.Base(pkg))
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	if len(name) == 0 || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// declaredInDir returns the top-level identifiers declared by go files in dir,
+// except generated files and the file exclude.
+func declaredInDir(dir, exclude string) (sets.Set[string], error) {
+	declared := sets.New[string]()
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return declared, nil
+	}
+	if err != nil {
+		log.Error("failed to read directory", "directory", dir, "cause", err)
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		fp := filepath.Join(dir, name)
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || isGeneratedFile(name) || fp == filepath.Clean(exclude) {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), fp, nil, parser.SkipObjectResolution)
+		if err != nil {
+			log.Error("failed to parse file", "file", fp, "cause", err)
+			return nil, err
+		}
+		declared.Insert(declaredNames(f)...)
+	}
+	return declared, nil
+}
+
+// declaredNames returns the top-level types, constants, variables and functions declared in f.
+// Methods are returned as "Type.Method".
+func declaredNames(f *ast.File) []string {
+	var names []string
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names = append(names, d.Name.Name)
+			} else if len(d.Recv.List) == 1 {
+				names = append(names, recvTypeName(d.Recv.List[0].Type)+"."+d.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						names = append(names, n.Name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// recvTypeName returns the type name of a method receiver, e.g. "Foo" of "*Foo".
+func recvTypeName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return recvTypeName(e.X)
+	case *ast.IndexExpr:
+		return recvTypeName(e.X)
+	case *ast.IndexListExpr:
+		return recvTypeName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+// generatedFilesInDir returns the files generated in dir.
+func generatedFilesInDir(dir string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+generatedFileSuffix))
+	validates, _ := filepath.Glob(filepath.Join(dir, "*"+validateFileSuffix))
+	return append(matches, validates...)
+}
@@ -0,0 +1,111 @@
+package generator
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPackageNameInDir(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		want      string
+		wantFound bool
+	}{
+		{
+			name:      "declared package",
+			files:     map[string]string{"types.go": "package apiv1\n"},
+			want:      "apiv1",
+			wantFound: true,
+		},
+		{
+			name: "test and generated files are skipped",
+			files: map[string]string{
+				"types_test.go":             "package apiv1_test\n",
+				"foo" + generatedFileSuffix: "package old\n",
+			},
+		},
+		{
+			name: "unparseable files are skipped",
+			files: map[string]string{
+				"broken.go": "packag apiv1\n",
+				"types.go":  "package apiv1\n",
+			},
+			want:      "apiv1",
+			wantFound: true,
+		},
+		{
+			name:  "non-go files only",
+			files: map[string]string{"README.md": "package v1\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			got, found, err := packageNameInDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("packageNameInDir() = %q, %v, want %q, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestPackageNameInMissingDir(t *testing.T) {
+	got, found, err := packageNameInDir(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || found || len(got) > 0 {
+		t.Errorf("packageNameInDir() = %q, %v, %v, want not found", got, found, err)
+	}
+}
+
+func TestPackageNameFromPath(t *testing.T) {
+	tests := []struct {
+		pkg  string
+		want string
+	}{
+		{pkg: "example.com/foo/api/v1", want: "v1"},
+		{pkg: "example.com/foo/my-api.io", want: "my_api_io"},
+		{pkg: "example.com/foo/API", want: "api"},
+		{pkg: "example.com/foo/1beta", want: "_1beta"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pkg, func(t *testing.T) {
+			if got := packageNameFromPath(tt.pkg); got != tt.want {
+				t.Errorf("packageNameFromPath(%q) = %q, want %q", tt.pkg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitResourcePackage(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"api/v1/types.go": "package apiv1\n"})
+	tests := []struct {
+		name          string
+		pkg           string
+		wantDir       string
+		wantGoPackage string
+		wantErr       bool
+	}{
+		{name: "module", pkg: "example.com/foo", wantDir: base, wantGoPackage: "main"},
+		{name: "empty", wantDir: base, wantGoPackage: "main"},
+		{name: "existing package", pkg: "example.com/foo/api/v1", wantDir: filepath.Join(base, "api", "v1"), wantGoPackage: "apiv1"},
+		{name: "new package", pkg: "example.com/foo/api/v2", wantDir: filepath.Join(base, "api", "v2"), wantGoPackage: "v2"},
+		{name: "outside module", pkg: "example.com/foobar/api", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Resource{Kind: "Foo", Package: tt.pkg}
+			err := initResourcePackage(r, base, "example.com/foo")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initResourcePackage() = %v, want error %v", err, tt.wantErr)
+			}
+			if r.Dir != tt.wantDir || r.GoPackage != tt.wantGoPackage {
+				t.Errorf("dir, package = %q, %q, want %q, %q", r.Dir, r.GoPackage, tt.wantDir, tt.wantGoPackage)
+			}
+		})
+	}
+}
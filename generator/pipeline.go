@@ -0,0 +1,290 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+	"gopkg.in/yaml.v3"
+)
+
+// builtin post-generation steps
+const (
+	StepGoFmt     = "gofmt"
+	StepGoImports = "goimports"
+	StepTidy      = "tidy"
+	StepVendor    = "vendor"
+)
+
+const (
+	msgAmbiguousStep      = `post-generation step cannot have both builtin and command`
+	msgEmptyStep          = `post-generation step must have either builtin or command`
+	msgUnknownBuiltinStep = `unknown builtin post-generation step; must be one of gofmt, goimports, tidy, vendor`
+	msgStepNeedsNetwork   = `step requires network access`
+	msgIrreversibleStep   = `vendor and commands cannot be rolled back; gofmt, goimports and tidy must run before them`
+	msgKeepFiles          = `generated files are kept since vendor and commands cannot be rolled back`
+)
+
+// Step is a post-generation step. It is either a builtin step or an arbitrary command.
+//
+//	postGenerate:
+//	- gofmt
+//	- goimports
+//	- tidy
+//	- command: [make, manifests]
+//	  network: false
+type Step struct {
+	Builtin string `yaml:"builtin"`
+
+	// Command is run with config.Base as working directory.
+	Command []string `yaml:"command"`
+	// Network marks a command as network-dependent, so it will be skipped in offline mode.
+	Network bool `yaml:"network"`
+}
+
+// UnmarshalYAML accepts a scalar as the name of a builtin step.
+func (s *Step) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		s.Builtin = value.Value
+		return nil
+	}
+	type plain Step
+	return value.Decode((*plain)(s))
+}
+
+func (s Step) String() string {
+	if len(s.Builtin) > 0 {
+		return s.Builtin
+	}
+	return strings.Join(s.Command, " ")
+}
+
+func (s Step) needsNetwork() bool {
+	switch s.Builtin {
+	case StepTidy, StepVendor:
+		return true
+	case StepGoFmt, StepGoImports:
+		return false
+	}
+	return s.Network
+}
+
+// reversible reports whether the changes of the step can be rolled back with the transaction.
+// gofmt and goimports rewrite generated files through it, and tidy changes go.mod and go.sum only,
+// which are tracked by it.
+// vendor and commands may write any file, so they cannot.
+func (s Step) reversible() bool {
+	switch s.Builtin {
+	case StepGoFmt, StepGoImports, StepTidy:
+		return true
+	}
+	return false
+}
+
+func (s Step) validate() error {
+	switch {
+	case len(s.Builtin) > 0 && len(s.Command) > 0:
+		return errors.New(msgAmbiguousStep)
+	case len(s.Builtin) == 0 && len(s.Command) == 0:
+		return errors.New(msgEmptyStep)
+	case len(s.Builtin) > 0:
+		switch s.Builtin {
+		case StepGoFmt, StepGoImports, StepTidy, StepVendor:
+			return nil
+		}
+		return errors.New(msgUnknownBuiltinStep)
+	}
+	return nil
+}
+
+func defaultPostGenerate() []Step {
+	return []Step{{Builtin: StepTidy}}
+}
+
+func (c *Controller) initPostGenerate() error {
+	if c.PostGenerate == nil {
+		c.PostGenerate = defaultPostGenerate()
+	}
+	irreversible := false
+	for i := range c.PostGenerate {
+		if err := c.PostGenerate[i].validate(); err != nil {
+			log.Error(msgConfigInvalid, "cause", err, "step", c.PostGenerate[i].String())
+			return errors.New(msgConfigInvalid)
+		}
+		// a failure after an irreversible step would roll back only part of the changes
+		if irreversible && c.PostGenerate[i].reversible() {
+			log.Error(msgConfigInvalid, "cause", msgIrreversibleStep, "step", c.PostGenerate[i].String())
+			return errors.New(msgConfigInvalid)
+		}
+		irreversible = irreversible || !c.PostGenerate[i].reversible()
+	}
+	return nil
+}
+
+// RunPostGenerate runs the post-generation steps in order.
+// In offline mode, steps that require network are skipped.
+// gofmt and goimports only rewrite the go files staged in tx.
+//
+// If a reversible step fails, tx is rolled back.
+// If vendor or a command fails, the generated files are kept.
+func RunPostGenerate(tx *Transaction, config *Controller, offline bool) error {
+	for _, step := range config.PostGenerate {
+		if offline && step.needsNetwork() {
+			log.Warn("skip step in offline mode", "step", step.String(), "cause", msgStepNeedsNetwork)
+			continue
+		}
+		log.Info("run post-generation step", "step", step.String())
+		var err error
+		switch step.Builtin {
+		case StepGoFmt:
+			err = rewriteGoFiles(tx, format.Source)
+		case StepGoImports:
+			err = rewriteGoFiles(tx, func(src []byte) ([]byte, error) {
+				return groupImports(src, config.Go.Module)
+			})
+		case StepTidy:
+			err = runCommand(config.Base, "go", "mod", "tidy")
+		case StepVendor:
+			err = runCommand(config.Base, "go", "mod", "vendor")
+		default:
+			err = runCommand(config.Base, step.Command[0], step.Command[1:]...)
+		}
+		if err != nil {
+			log.Error("failed to run post-generation step", "step", step.String(), "cause", err)
+			if !step.reversible() {
+				log.Warn(msgKeepFiles, "step", step.String())
+				return err
+			}
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return errors.Join(err, rbErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func runCommand(dir, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// rewriteGoFiles applies fn to the go files staged in tx and rewrites files that have changed through tx.
+// Other files under base are left as they are.
+func rewriteGoFiles(tx *Transaction, fn func(src []byte) ([]byte, error)) error {
+	for _, path := range tx.goFiles() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, err := fn(src)
+		if err != nil {
+			log.Error("failed to process file", "file", path, "cause", err)
+			return err
+		}
+		if bytes.Equal(src, out) {
+			continue
+		}
+		log.Info("write to file", "file", path)
+		if err = tx.Rewrite(path, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupImports sorts imports into three groups like goimports does:
+// standard library, third-party packages and packages of the local module.
+//
+// Only a single parenthesized import declaration is regrouped;
+// other files are just formatted.
+func groupImports(src []byte, module string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var decl *ast.GenDecl
+	for _, d := range file.Decls {
+		g, ok := d.(*ast.GenDecl)
+		if !ok || g.Tok != token.IMPORT {
+			continue
+		}
+		if decl != nil || !g.Lparen.IsValid() {
+			return format.Source(src)
+		}
+		decl = g
+	}
+	if decl == nil || len(decl.Specs) == 0 {
+		return format.Source(src)
+	}
+	// free-floating comments in the block cannot be assigned to a group
+	attached := make(map[*ast.CommentGroup]bool, 2*len(decl.Specs))
+	for _, spec := range decl.Specs {
+		imp := spec.(*ast.ImportSpec)
+		attached[imp.Doc], attached[imp.Comment] = true, true
+	}
+	for _, c := range file.Comments {
+		if c.Pos() > decl.Lparen && c.End() < decl.Rparen && !attached[c] {
+			return format.Source(src)
+		}
+	}
+
+	type entry struct {
+		path string
+		text string
+	}
+	groups := make([][]entry, 3)
+	for _, spec := range decl.Specs {
+		imp := spec.(*ast.ImportSpec)
+		start, end := imp.Pos(), imp.End()
+		if imp.Doc != nil {
+			start = imp.Doc.Pos()
+		}
+		if imp.Comment != nil {
+			end = imp.Comment.End()
+		}
+		path, _ := strconv.Unquote(imp.Path.Value)
+		e := entry{path: path, text: string(src[fset.Position(start).Offset:fset.Position(end).Offset])}
+		switch {
+		case len(module) > 0 && (path == module || strings.HasPrefix(path, module+"/")):
+			groups[2] = append(groups[2], e)
+		case !strings.Contains(strings.Split(path, "/")[0], "."):
+			groups[0] = append(groups[0], e)
+		default:
+			groups[1] = append(groups[1], e)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(src[:fset.Position(decl.Lparen).Offset+1])
+	buf.WriteString(NewLine)
+	first := true
+	for _, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		sort.SliceStable(group, func(i, j int) bool { return group[i].path < group[j].path })
+		if !first {
+			buf.WriteString(NewLine)
+		}
+		first = false
+		for _, e := range group {
+			buf.WriteString(Tab + e.text + NewLine)
+		}
+	}
+	buf.Write(src[fset.Position(decl.Rparen).Offset:])
+	return format.Source(buf.Bytes())
+}
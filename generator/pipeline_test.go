@@ -0,0 +1,231 @@
+package generator
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGroupImports(t *testing.T) {
+	tests := []struct {
+		name   string
+		module string
+		src    string
+		want   string
+	}{
+		{
+			name:   "standard library, third-party and local module",
+			module: "example.com/foo",
+			src: `package main
+
+import (
+	"example.com/foo/api/v1"
+	"fmt"
+	"github.com/spf13/pflag"
+	apiv2 "example.com/foo/api/v2"
+	"context"
+)
+`,
+			want: `package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/pflag"
+
+	"example.com/foo/api/v1"
+	apiv2 "example.com/foo/api/v2"
+)
+`,
+		},
+		{
+			name: "without module",
+			src: `package main
+
+import (
+	"k8s.io/klog/v2"
+	"errors"
+
+	apiv1 "example.com/foo/api/v1"
+	"net/http"
+)
+`,
+			want: `package main
+
+import (
+	"errors"
+	"net/http"
+
+	apiv1 "example.com/foo/api/v1"
+	"k8s.io/klog/v2"
+)
+`,
+		},
+		{
+			name: "comments move with their imports",
+			src: `package main
+
+import (
+	"k8s.io/klog/v2" // logging
+	// errors of the standard library
+	"errors"
+)
+`,
+			want: `package main
+
+import (
+	// errors of the standard library
+	"errors"
+
+	"k8s.io/klog/v2" // logging
+)
+`,
+		},
+		{
+			name: "free-floating comments keep the order",
+			src: `package main
+
+import (
+	"k8s.io/klog/v2"
+
+	// keep this block as it is
+
+	"errors"
+)
+`,
+			want: `package main
+
+import (
+	"k8s.io/klog/v2"
+
+	// keep this block as it is
+
+	"errors"
+)
+`,
+		},
+		{
+			name: "single import",
+			src: `package main
+
+import "fmt"
+`,
+			want: `package main
+
+import "fmt"
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := groupImports([]byte(tt.src), tt.module)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupImportsInvalid(t *testing.T) {
+	if _, err := groupImports([]byte("package main\nimport (\n"), ""); err == nil {
+		t.Error("expected an error for invalid source")
+	}
+}
+
+func TestInitPostGenerate(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []Step
+		wantErr bool
+	}{
+		{name: "default"},
+		{
+			name:  "irreversible steps last",
+			steps: []Step{{Builtin: StepGoFmt}, {Builtin: StepTidy}, {Builtin: StepVendor}, {Command: []string{"make"}}},
+		},
+		{
+			name:    "unknown builtin",
+			steps:   []Step{{Builtin: "lint"}},
+			wantErr: true,
+		},
+		{
+			name:    "builtin and command",
+			steps:   []Step{{Builtin: StepGoFmt, Command: []string{"make"}}},
+			wantErr: true,
+		},
+		{
+			name:    "tidy after vendor",
+			steps:   []Step{{Builtin: StepVendor}, {Builtin: StepTidy}},
+			wantErr: true,
+		},
+		{
+			name:    "gofmt after command",
+			steps:   []Step{{Command: []string{"make"}}, {Builtin: StepGoFmt}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{PostGenerate: tt.steps}
+			if err := c.initPostGenerate(); (err != nil) != tt.wantErr {
+				t.Fatalf("initPostGenerate() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.steps == nil && (len(c.PostGenerate) != 1 || c.PostGenerate[0].Builtin != StepTidy) {
+				t.Errorf("default steps = %v", c.PostGenerate)
+			}
+		})
+	}
+}
+
+func TestRunPostGenerate(t *testing.T) {
+	const (
+		unformatted = "package main\nfunc  main() {}\n"
+		formatted   = "package main\n\nfunc main() {}\n"
+	)
+	fail := Step{Command: []string{"sh", "-c", "exit 1"}}
+	tests := []struct {
+		name    string
+		steps   []Step
+		wantErr bool
+		// want is the content of main.go after the steps
+		want string
+	}{
+		{
+			name:  "success",
+			steps: []Step{{Builtin: StepGoFmt}},
+			want:  formatted,
+		},
+		{
+			name:    "irreversible step fails",
+			steps:   []Step{{Builtin: StepGoFmt}, fail},
+			wantErr: true,
+			want:    formatted,
+		},
+		{
+			name:    "reversible step fails",
+			steps:   []Step{{Builtin: StepGoFmt}, {Builtin: StepTidy}},
+			wantErr: true,
+			want:    "old main",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// go mod tidy fails without go.mod
+			writeFiles(t, dir, map[string]string{"main.go": "old main"})
+			tx := NewTransaction()
+			tx.Add(filepath.Join(dir, "main.go"), []byte(unformatted))
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			config := &Controller{Base: dir, PostGenerate: tt.steps}
+			if err := RunPostGenerate(tx, config, false); (err != nil) != tt.wantErr {
+				t.Fatalf("RunPostGenerate() = %v, want error %v", err, tt.wantErr)
+			}
+			checkFiles(t, dir, map[string]string{"main.go": tt.want})
+		})
+	}
+}
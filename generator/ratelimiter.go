@@ -0,0 +1,128 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/FlyingOnion/pkg/log"
+)
+
+const (
+	msgInvalidRateLimiterType = `rateLimiter.type must be one of exponential, bucket, max`
+	msgInvalidBaseDelay       = `rateLimiter.baseDelay must be a positive go duration, e.g. 5ms`
+	msgInvalidMaxDelay        = `rateLimiter.maxDelay must be a go duration not shorter than baseDelay, e.g. 1000s`
+	msgInvalidBucket          = `rateLimiter.qps and rateLimiter.burst must be positive`
+)
+
+// RateLimiterType decides how long a failed key waits before it is requeued.
+type RateLimiterType string
+
+const (
+	// RateLimiterExponential delays each key by baseDelay*2^<failures of the key>, up to maxDelay.
+	RateLimiterExponential RateLimiterType = "exponential"
+	// RateLimiterBucket limits the requeues of all keys by a token bucket of qps and burst.
+	RateLimiterBucket RateLimiterType = "bucket"
+	// RateLimiterMax delays each key by the longer delay of exponential and bucket,
+	// which is workqueue.DefaultControllerRateLimiter with the default parameters.
+	RateLimiterMax RateLimiterType = "max"
+)
+
+// RateLimiter is the rate limiter of the workqueue.
+//
+//	rateLimiter:
+//	  type: max # exponential, bucket or max
+//	  baseDelay: 5ms
+//	  maxDelay: 1000s
+//	  qps: 10
+//	  burst: 100
+type RateLimiter struct {
+	Type      RateLimiterType `yaml:"type"`
+	BaseDelay string          `yaml:"baseDelay"`
+	MaxDelay  string          `yaml:"maxDelay"`
+	QPS       float64         `yaml:"qps"`
+	Burst     int             `yaml:"burst"`
+
+	// BaseDelayLiteral, MaxDelayLiteral and QPSLiteral are the go expressions of the parameters in main.go.
+	BaseDelayLiteral string `yaml:"-"`
+	MaxDelayLiteral  string `yaml:"-"`
+	QPSLiteral       string `yaml:"-"`
+}
+
+func defaultRateLimiter() RateLimiter {
+	return RateLimiter{
+		Type:      RateLimiterMax,
+		BaseDelay: "5ms",
+		MaxDelay:  "1000s",
+		QPS:       10,
+		Burst:     100,
+	}
+}
+
+// Exponential reports whether the rate limiter has a per-item exponential backoff.
+func (r *RateLimiter) Exponential() bool {
+	return r.Type == RateLimiterExponential || r.Type == RateLimiterMax
+}
+
+// Bucket reports whether the rate limiter has a token bucket.
+func (r *RateLimiter) Bucket() bool {
+	return r.Type == RateLimiterBucket || r.Type == RateLimiterMax
+}
+
+// initRateLimiter checks the rate limiter, and adds the imports of newRateLimiter in main.go.
+func (c *Controller) initRateLimiter() error {
+	if err := c.RateLimiter.init(); err != nil {
+		log.Error(msgConfigInvalid, "cause", err)
+		return errors.New(msgConfigInvalid)
+	}
+	if c.RateLimiter.Bucket() {
+		c.MainImports = append(c.MainImports, `"golang.org/x/time/rate"`)
+	}
+	// newRateLimiter in main.go returns an error for invalid flags
+	c.MainImports = append(c.MainImports, `"fmt"`)
+	return nil
+}
+
+// init checks the parameters of r that are used by its type, and sets their go expressions.
+// Parameters that are not set keep the values of workqueue.DefaultControllerRateLimiter.
+func (r *RateLimiter) init() error {
+	defaults := defaultRateLimiter()
+	switch r.Type {
+	case "":
+		r.Type = defaults.Type
+	case RateLimiterExponential, RateLimiterBucket, RateLimiterMax:
+	default:
+		return fmt.Errorf("%s: %q", msgInvalidRateLimiterType, r.Type)
+	}
+	if r.Exponential() {
+		if len(r.BaseDelay) == 0 {
+			r.BaseDelay = defaults.BaseDelay
+		}
+		if len(r.MaxDelay) == 0 {
+			r.MaxDelay = defaults.MaxDelay
+		}
+		base, err := time.ParseDuration(r.BaseDelay)
+		if err != nil || base <= 0 {
+			return errors.New(msgInvalidBaseDelay)
+		}
+		maxDelay, err := time.ParseDuration(r.MaxDelay)
+		if err != nil || maxDelay < base {
+			return errors.New(msgInvalidMaxDelay)
+		}
+		r.BaseDelayLiteral, r.MaxDelayLiteral = durationLiteral(base), durationLiteral(maxDelay)
+	}
+	if r.Bucket() {
+		if r.QPS == 0 {
+			r.QPS = defaults.QPS
+		}
+		if r.Burst == 0 {
+			r.Burst = defaults.Burst
+		}
+		if r.QPS < 0 || r.Burst < 0 {
+			return errors.New(msgInvalidBucket)
+		}
+		r.QPSLiteral = strconv.FormatFloat(r.QPS, 'g', -1, 64)
+	}
+	return nil
+}
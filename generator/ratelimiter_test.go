@@ -0,0 +1,110 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitRateLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       RateLimiter
+		want    RateLimiter
+		wantErr bool
+	}{
+		{
+			name: "default",
+			want: RateLimiter{Type: RateLimiterMax, BaseDelay: "5ms", MaxDelay: "1000s", QPS: 10, Burst: 100,
+				BaseDelayLiteral: "5*time.Millisecond", MaxDelayLiteral: "1000*time.Second", QPSLiteral: "10"},
+		},
+		{
+			name: "exponential",
+			r:    RateLimiter{Type: RateLimiterExponential, BaseDelay: "1s", MaxDelay: "5m"},
+			want: RateLimiter{Type: RateLimiterExponential, BaseDelay: "1s", MaxDelay: "5m",
+				BaseDelayLiteral: "1*time.Second", MaxDelayLiteral: "5*time.Minute"},
+		},
+		{
+			name: "bucket",
+			r:    RateLimiter{Type: RateLimiterBucket, QPS: 0.5},
+			want: RateLimiter{Type: RateLimiterBucket, QPS: 0.5, Burst: 100, QPSLiteral: "0.5"},
+		},
+		{name: "unknown type", r: RateLimiter{Type: "fixed"}, wantErr: true},
+		{name: "zero base delay", r: RateLimiter{Type: RateLimiterExponential, BaseDelay: "0s"}, wantErr: true},
+		{name: "max delay shorter than base delay", r: RateLimiter{Type: RateLimiterMax, BaseDelay: "1s", MaxDelay: "1ms"}, wantErr: true},
+		{name: "negative burst", r: RateLimiter{Type: RateLimiterBucket, Burst: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{RateLimiter: tt.r}
+			err := c.initRateLimiter()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initRateLimiter() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && c.RateLimiter != tt.want {
+				t.Errorf("rate limiter = %+v, want %+v", c.RateLimiter, tt.want)
+			}
+			if tt.want.Bucket() != hasLine(c.MainImports, `"golang.org/x/time/rate"`) {
+				t.Errorf("main imports = %q", c.MainImports)
+			}
+		})
+	}
+}
+
+// rateLimiterTests check the delays of the generated newRateLimiter of each type.
+var rateLimiterTests = map[RateLimiterType]string{
+	RateLimiterExponential: `
+	r, err := newRateLimiter(time.Second, 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if got := r.When("a"); got != want {
+			t.Errorf("When() = %v, want %v", got, want)
+		}
+	}
+	if _, err = newRateLimiter(time.Second, time.Millisecond); err == nil {
+		t.Error("newRateLimiter() with max delay shorter than base delay error = nil")
+	}`,
+	RateLimiterBucket: `
+	r, err := newRateLimiter(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.When("a") != 0 || r.When("b") != 0 || r.When("c") == 0 {
+		t.Error("keys after the burst must wait")
+	}
+	if _, err = newRateLimiter(0, 1); err == nil {
+		t.Error("newRateLimiter() with zero qps error = nil")
+	}`,
+	RateLimiterMax: `
+	r, err := newRateLimiter(time.Hour, 2*time.Hour, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.When("a"); got != time.Hour {
+		t.Errorf("When() = %v, want the exponential delay", got)
+	}
+	if _, err = newRateLimiter(time.Second, time.Minute, 1, 0); err == nil {
+		t.Error("newRateLimiter() with zero burst error = nil")
+	}`,
+}
+
+func TestGeneratedRateLimiter(t *testing.T) {
+	for typ, body := range rateLimiterTests {
+		t.Run(string(typ), func(t *testing.T) {
+			config := "name: Foo\ngo:\n  module: example.com/generated\nrateLimiter:\n  type: " + string(typ) + "\nresources:\n- kind: Pod\n  isNamespaced: true\n"
+			test := "package main\n\nimport (\n\t\"testing\"\n\t\"time\"\n)\n\nvar _ = time.Second\n\nfunc TestNewRateLimiter(t *testing.T) {" + body + "\n}\n"
+			dir := generateController(t, config, map[string]string{"ratelimiter_test.go": test})
+			b, err := os.ReadFile(filepath.Join(dir, "main.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "queue := workqueue.NewRateLimitingQueue(rateLimiter)"; !strings.Contains(string(b), want) {
+				t.Errorf("missing %q in main.go", want)
+			}
+			runGoTest(t, dir)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package generator
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	msgInconsistentGroupVersion    = `resources in the same package must have the same group and version`
+	msgInconsistentGroupVersionTip = `put resources of different group versions into different packages, e.g. <module>/api/<group>/<version>`
+	msgNoListType                  = `list type not found in package; only the kind is registered`
+	msgNoListTypeTip               = `declare the list type to list and watch the resource`
+)
+
+const (
+	registerFileName = "register.go"
+	generatedHeader  = "// Code generated by koolbuilder. DO NOT EDIT."
+)
+
+// RegisterFile is the data of template register.
+type RegisterFile struct {
+	Package string
+	Group   string
+	Version string
+	// Types are the kinds and their list types to register
+	Types []string
+}
+
+// CreateOrRewriteRegister generates register.go with SchemeGroupVersion, SchemeBuilder and AddToScheme
+// in each package of custom resources whose template is generated.
+//
+// A package that already has its own AddToScheme is left untouched.
+func CreateOrRewriteRegister(tx *Transaction, tmpl *template.Template, config *Controller) error {
+	files := make(map[string]*RegisterFile)
+	dirs := make([]string, 0)
+	for _, r := range config.generatedResources() {
+		file, ok := files[r.Dir]
+		if !ok {
+			file = &RegisterFile{Package: r.GoPackage, Group: r.SchemaGroup, Version: r.Version}
+			files[r.Dir] = file
+			dirs = append(dirs, r.Dir)
+		}
+		file.Types = append(file.Types, r.Kind)
+	}
+
+	for _, dir := range dirs {
+		file := files[dir]
+		fp := filepath.Join(dir, registerFileName)
+		existing, err := os.ReadFile(fp)
+		if err == nil && !bytes.HasPrefix(existing, []byte(generatedHeader)) {
+			log.Info("register.go is not generated by koolbuilder; skip", "file", fp)
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("failed to read file", "file", fp, "cause", err)
+			return err
+		}
+
+		declared, err := declaredInDir(dir, fp)
+		if err != nil {
+			return err
+		}
+		// definitions that are staged but not written yet
+		for p, b := range tx.StagedInDir(dir) {
+			if p == filepath.Clean(fp) || len(b) == 0 {
+				continue
+			}
+			if f, err := parser.ParseFile(token.NewFileSet(), p, b, parser.SkipObjectResolution); err == nil {
+				declared.Insert(declaredNames(f)...)
+			}
+		}
+		if declared.HasAny("AddToScheme", "SchemeGroupVersion") {
+			log.Info("AddToScheme is already declared in package; skip register.go", "directory", dir)
+			continue
+		}
+
+		kinds := file.Types
+		file.Types = make([]string, 0, 2*len(kinds))
+		for _, kind := range kinds {
+			file.Types = append(file.Types, kind)
+			if declared.Has(kind + "List") {
+				file.Types = append(file.Types, kind+"List")
+				continue
+			}
+			log.Warn(msgNoListType, "resource", kind, "list", kind+"List", "tip", msgNoListTypeTip)
+		}
+
+		log.Info("write register", "group", file.Group, "version", file.Version, "types", strings.Join(file.Types, ", "), "file", fp)
+		b, err := renderGo(tmpl, file)
+		if err != nil {
+			return err
+		}
+		tx.Add(fp, b)
+	}
+	return nil
+}
+
+// initSchemeRegistration fills the scheme registration of main.go.
+//
+// Packages of generated templates are registered by their AddToScheme.
+// Other custom resources are registered by their kind only, since their list types are unknown.
+func (c *Controller) initSchemeRegistration() error {
+	if err := c.checkVersionsOfPackages(); err != nil {
+		return err
+	}
+	registered := sets.New[string]()
+	byDir := sets.New[string]()
+	needsMetaV1 := false
+	for i := range c.Resources {
+		r := &(c.Resources[i])
+		if !r.IsCustom {
+			continue
+		}
+		gv := `schema.GroupVersion{Group: "` + r.SchemaGroup + `", Version: "` + r.Version + `"}`
+		if r.Template == TemplateNone {
+			c.SchemeRegistrations = append(c.SchemeRegistrations, "s.AddKnownTypes("+gv+", &"+r.GoType+"{})")
+			if !registered.Has(gv) {
+				registered.Insert(gv)
+				c.SchemeRegistrations = append(c.SchemeRegistrations, "metav1.AddToGroupVersion(s, "+gv+")")
+			}
+			needsMetaV1 = true
+			continue
+		}
+		if byDir.Has(r.Dir) {
+			continue
+		}
+		byDir.Insert(r.Dir)
+		// GoType is "Kind" in main package, or "alias.Kind"
+		c.SchemeRegistrations = append(c.SchemeRegistrations, "utilruntime.Must("+strings.TrimSuffix(r.GoType, r.Kind)+"AddToScheme(s))")
+		c.MainImports = append(c.MainImports, `utilruntime "k8s.io/apimachinery/pkg/util/runtime"`)
+	}
+	if needsMetaV1 {
+		c.MainImports = append(c.MainImports, `metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`)
+	}
+	c.MainImports = sets.List(sets.New(c.MainImports...))
+	return nil
+}
@@ -0,0 +1,160 @@
+package generator
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreateOrRewriteRegister(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		// staged are files staged before, e.g. definitions
+		staged map[string]string
+		// want is false if register.go is skipped
+		want bool
+		// contains are strings expected in register.go
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "kind and list",
+			files:    map[string]string{"types_foo.go": "package v1\n\ntype Foo struct{}\ntype FooList struct{}\n"},
+			want:     true,
+			contains: []string{"package v1", `const GroupName = "example.com"`, `Version: "v1"`, "&Foo{},", "&FooList{},"},
+		},
+		{
+			name:     "without list",
+			files:    map[string]string{"types_foo.go": "package v1\n\ntype Foo struct{}\n"},
+			want:     true,
+			contains: []string{"&Foo{},"},
+			excludes: []string{"FooList"},
+		},
+		{
+			name:     "staged definition",
+			staged:   map[string]string{"types_foo.go": "package v1\n\ntype Foo struct{}\ntype FooList struct{}\n"},
+			want:     true,
+			contains: []string{"&FooList{},"},
+		},
+		{
+			name:     "generated register.go is rewritten",
+			files:    map[string]string{"register.go": generatedHeader + "\n\npackage v1\n\nfunc AddToScheme() {}\n"},
+			want:     true,
+			contains: []string{"AddToScheme = SchemeBuilder.AddToScheme"},
+		},
+		{
+			name:  "register.go of the user",
+			files: map[string]string{"register.go": "package v1\n\nvar SchemeBuilder = 1\n"},
+		},
+		{
+			name:  "AddToScheme declared in another file",
+			files: map[string]string{"scheme.go": "package v1\n\nfunc AddToScheme() {}\n"},
+		},
+		{
+			name:  "SchemeGroupVersion declared in another file",
+			files: map[string]string{"scheme.go": "package v1\n\nvar SchemeGroupVersion = 1\n"},
+		},
+		{
+			name:   "AddToScheme in a staged file",
+			staged: map[string]string{"scheme.go": "package v1\n\nvar AddToScheme = 1\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			tx := NewTransaction()
+			for name, content := range tt.staged {
+				tx.Add(filepath.Join(dir, name), []byte(content))
+			}
+			config := withVersions(&Controller{Resources: []Resource{{
+				Kind:        "Foo",
+				IsCustom:    true,
+				Template:    TemplateBoth,
+				Dir:         dir,
+				GoPackage:   "v1",
+				SchemaGroup: "example.com",
+				Version:     "v1",
+			}}})
+			if err := CreateOrRewriteRegister(tx, parseTemplate(t, "register.go.tmpl"), config); err != nil {
+				t.Fatal(err)
+			}
+			b, ok := tx.StagedInDir(dir)[filepath.Join(dir, registerFileName)]
+			if ok != tt.want {
+				t.Fatalf("register.go staged = %v, want %v", ok, tt.want)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(string(b), want) {
+					t.Errorf("missing %q in:\n%s", want, b)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(string(b), unwanted) {
+					t.Errorf("unexpected %q in:\n%s", unwanted, b)
+				}
+			}
+		})
+	}
+}
+
+func TestInitSchemeRegistration(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []Resource
+		want      []string
+		imports   []string
+		wantErr   bool
+	}{
+		{
+			name: "generated packages",
+			resources: []Resource{
+				{Kind: "Foo", GoType: "apiv1.Foo", IsCustom: true, Template: TemplateBoth, Dir: "api/v1", SchemaGroup: "example.com", Version: "v1"},
+				{Kind: "Bar", GoType: "apiv1.Bar", IsCustom: true, Template: TemplateBoth, Dir: "api/v1", SchemaGroup: "example.com", Version: "v1"},
+				{Kind: "Pod", GoType: "corev1.Pod"},
+			},
+			want:    []string{"utilruntime.Must(apiv1.AddToScheme(s))"},
+			imports: []string{`utilruntime "k8s.io/apimachinery/pkg/util/runtime"`},
+		},
+		{
+			name: "template none",
+			resources: []Resource{
+				{Kind: "Foo", GoType: "ext.Foo", IsCustom: true, Template: TemplateNone, SchemaGroup: "example.com", Version: "v1"},
+				{Kind: "Bar", GoType: "ext.Bar", IsCustom: true, Template: TemplateNone, SchemaGroup: "example.com", Version: "v1"},
+			},
+			want: []string{
+				`s.AddKnownTypes(schema.GroupVersion{Group: "example.com", Version: "v1"}, &ext.Foo{})`,
+				`metav1.AddToGroupVersion(s, schema.GroupVersion{Group: "example.com", Version: "v1"})`,
+				`s.AddKnownTypes(schema.GroupVersion{Group: "example.com", Version: "v1"}, &ext.Bar{})`,
+			},
+			imports: []string{`metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`},
+		},
+		{
+			name: "different group versions in a package",
+			resources: []Resource{
+				{Kind: "Foo", GoType: "apiv1.Foo", IsCustom: true, Template: TemplateBoth, Dir: "api/v1", SchemaGroup: "example.com", Version: "v1"},
+				{Kind: "Bar", GoType: "apiv1.Bar", IsCustom: true, Template: TemplateBoth, Dir: "api/v1", SchemaGroup: "example.com", Version: "v2"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := withVersions(&Controller{Resources: tt.resources})
+			err := c.initSchemeRegistration()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initSchemeRegistration() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(c.SchemeRegistrations, tt.want) {
+				t.Errorf("registrations = %q, want %q", c.SchemeRegistrations, tt.want)
+			}
+			if !reflect.DeepEqual(c.MainImports, tt.imports) {
+				t.Errorf("imports = %q, want %q", c.MainImports, tt.imports)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"strings"
+	"text/template"
+
+	"github.com/FlyingOnion/pkg/log"
+)
+
+// excerptLines is the number of lines shown before and after an error line.
+const excerptLines = 3
+
+// renderGo executes tmpl and formats the result with go/format.
+// Imports are regrouped, so that standard library packages added by the config
+// are not sorted into the block of third-party packages.
+// If the result cannot be parsed, it logs the template name and an excerpt of the source
+// so that nothing unparseable is written to disk.
+func renderGo(tmpl *template.Template, data any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Error("failed to execute template", "template", tmpl.Name(), "cause", err)
+		return nil, err
+	}
+	b, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Error("generated code does not parse", "template", tmpl.Name(), "cause", err, "excerpt", excerpt(buf.Bytes(), err))
+		return nil, fmt.Errorf("template %s: %w", tmpl.Name(), err)
+	}
+	return groupImports(b, "")
+}
+
+// excerpt returns the source lines around the first error position.
+func excerpt(src []byte, err error) string {
+	var list scanner.ErrorList
+	if !errors.As(err, &list) || len(list) == 0 {
+		return ""
+	}
+	line := list[0].Pos.Line
+	lines := strings.Split(string(src), NewLine)
+	from, to := max(line-excerptLines, 1), min(line+excerptLines, len(lines))
+	var sb strings.Builder
+	for i := from; i <= to; i++ {
+		mark := "  "
+		if i == line {
+			mark = "> "
+		}
+		fmt.Fprintf(&sb, "\n%s%4d | %s", mark, i, lines[i-1])
+	}
+	return sb.String()
+}
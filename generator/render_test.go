@@ -0,0 +1,77 @@
+package generator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestRenderGo(t *testing.T) {
+	tmpl := template.Must(template.New("main").Parse("package {{ .Name }}\nfunc  f( ) {}\n"))
+	got, err := renderGo(tmpl, struct{ Name string }{"main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package main\n\nfunc f() {}\n"; string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderGoInvalid(t *testing.T) {
+	tmpl := template.Must(template.New("controller").Parse("package main\n\nfunc f() {\n\treturn {{ .Value }}\n}\n"))
+	_, err := renderGo(tmpl, struct{ Value string }{"1 +"})
+	if err == nil {
+		t.Fatal("expected an error for unparseable code")
+	}
+	if !strings.Contains(err.Error(), "template controller") {
+		t.Errorf("error %q does not name the template", err)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	src := []byte("package main\n\nimport \"fmt\"\n\nfunc f() {\n\tfmt.Println(\n}\n\nfunc g() {}\n\nfunc h() {}\n")
+	tmpl := template.Must(template.New("main").Parse(string(src)))
+	_, err := renderGo(tmpl, nil)
+	if err == nil {
+		t.Fatal("expected an error for unparseable code")
+	}
+	want := strings.Join([]string{
+		"",
+		"     4 | ",
+		"     5 | func f() {",
+		"     6 | \tfmt.Println(",
+		">    7 | }",
+		"     8 | ",
+		"     9 | func g() {}",
+		"    10 | ",
+	}, NewLine)
+	if got := excerpt(src, err); got != want {
+		t.Errorf("got:%s\nwant:%s", got, want)
+	}
+}
+
+func TestExcerptAtStart(t *testing.T) {
+	src := []byte("packag main\n\nfunc f() {}\n")
+	tmpl := template.Must(template.New("main").Parse(string(src)))
+	_, err := renderGo(tmpl, nil)
+	if err == nil {
+		t.Fatal("expected an error for unparseable code")
+	}
+	want := strings.Join([]string{
+		"",
+		">    1 | packag main",
+		"     2 | ",
+		"     3 | func f() {}",
+		"     4 | ",
+	}, NewLine)
+	if got := excerpt(src, err); got != want {
+		t.Errorf("got:%s\nwant:%s", got, want)
+	}
+}
+
+func TestExcerptOtherError(t *testing.T) {
+	if got := excerpt([]byte("package main\n"), errors.New("not a scanner error")); got != "" {
+		t.Errorf("got %q, want empty excerpt", got)
+	}
+}
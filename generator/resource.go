@@ -0,0 +1,154 @@
+package generator
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// initResources initializes the resources, and the fields of the templates that list them.
+func (c *Controller) initResources() error {
+	// initializations below uses len(c.Resources)
+	// so we need to ensure that it is not 0
+	if len(c.Resources) == 0 {
+		log.Error(msgConfigInvalid, "cause", msgNoResources)
+		return errors.New(msgConfigInvalid)
+	}
+
+	// imports is used to deal with extra imports
+	// it collects all unique imports and generates Controller.Imports
+	imports := sets.Set[string]{}
+
+	c.ListerFields = make([]string, 0, len(c.Resources))
+	c.HasSyncedFields = make([]string, 0, len(c.Resources))
+	c.StructFieldInits = make([]string, 0, 2*len(c.Resources))
+	c.InformerInits = make([]string, 0, 2*len(c.Resources))
+	c.InformerRuns = make([]string, 0, len(c.Resources))
+	c.NewControllerArgs = make([]string, 0, len(c.Resources))
+	c.NewControllerCallArgs = make([]string, 0, len(c.Resources))
+	c.MainFlags = make([]string, 0, 2)
+	if err := c.initDefaultResyncPeriod(); err != nil {
+		log.Error(msgConfigInvalid, "cause", err, "resyncPeriod", c.ResyncPeriod)
+		return errors.New(msgConfigInvalid)
+	}
+
+	clientInits := make([]string, 0, len(c.Resources))
+	informerInits := make([]string, 0, len(c.Resources))
+	for i := range c.Resources {
+		if err := c.initResource(&(c.Resources[i]), i == 0, imports); err != nil {
+			return err
+		}
+		clients, informers := c.initInformer(&(c.Resources[i]))
+		clientInits = append(clientInits, clients...)
+		informerInits = append(informerInits, informers...)
+	}
+	if c.HasMetadataInformers {
+		clientInits = append(clientInits, "metadataClient := mustGetOrLogFatal(metadata.NewForConfigAndClient(config, httpClient))")
+		c.MainImports = append(c.MainImports, `"k8s.io/client-go/metadata"`)
+		c.EventHandlerImports = append(c.EventHandlerImports, `metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"`)
+	}
+	c.initPrimaryIndexers()
+	c.InformerInits = append(c.InformerInits, clientInits...)
+	c.InformerInits = append(c.InformerInits, informerInits...)
+	importList := imports.UnsortedList()
+	sort.Strings(importList)
+	c.Imports = importList
+	return nil
+}
+
+// initResource initializes r and checks its configuration.
+// isMain is true for the main resource, which is the first one.
+func (c *Controller) initResource(r *Resource, isMain bool, imports sets.Set[string]) error {
+	if len(r.Kind) == 0 || r.Kind == "UnknownType" {
+		log.Error(msgConfigInvalid, "cause", msgUnknownResourceKind)
+		return errors.New(msgUnknownResourceKind)
+	}
+	// field initializations
+	r.LowerKind = strings.ToLower(r.Kind)
+	if len(r.Plural) == 0 {
+		r.Plural = pluralize(r.LowerKind)
+	}
+	if err := c.initResyncPeriod(r); err != nil {
+		log.Error(msgConfigInvalid, "cause", err, "resource", r.Kind, "resyncPeriod", r.ResyncPeriod)
+		return errors.New(msgConfigInvalid)
+	}
+	if err := r.validateSubresources(); err != nil {
+		log.Error(msgConfigInvalid, "cause", err, "resource", r.Kind)
+		return errors.New(msgConfigInvalid)
+	}
+	if err := r.validateVersionsTemplate(); err != nil {
+		log.Error(msgConfigInvalid, "cause", err, "resource", r.Kind)
+		return errors.New(msgConfigInvalid)
+	}
+	c.HasCustomResources = c.HasCustomResources || r.IsCustom
+	if err := c.initGroupVersion(r); err != nil {
+		return err
+	}
+	c.initGoType(r, imports)
+	if err := c.initCache(r, isMain); err != nil {
+		log.Error(msgConfigInvalid, "cause", err, "resource", r.Kind)
+		return errors.New(msgConfigInvalid)
+	}
+	if err := c.initSelectors(r); err != nil {
+		log.Error(msgConfigInvalid, "cause", err, "resource", r.Kind)
+		return errors.New(msgConfigInvalid)
+	}
+	if err := c.initEnqueueOwner(r, isMain); err != nil {
+		log.Error(msgConfigInvalid, "cause", err, "resource", r.Kind)
+		return errors.New(msgConfigInvalid)
+	}
+	if err := c.initMapToPrimary(r, isMain); err != nil {
+		log.Error(msgConfigInvalid, "cause", err, "resource", r.Kind)
+		return errors.New(msgConfigInvalid)
+	}
+	return nil
+}
+
+// initGroupVersion initializes the group, the version and the package of r.
+// The package of a custom resource whose template is generated is initialized with its versions.
+func (c *Controller) initGroupVersion(r *Resource) error {
+	if !r.IsCustom {
+		initGVPBuiltin(r)
+		return nil
+	}
+	if err := initCRD(r); err != nil {
+		return err
+	}
+	r.defaultWatchedVersion()
+	initGVPLocalAndThirdParty(r)
+	if r.Template == TemplateNone {
+		return nil
+	}
+	if err := initResourcePackage(r, c.Base, c.Go.Module); err != nil {
+		return err
+	}
+	r.HasValidate = true
+	if err := r.initVersions(c.Base, c.Go.Module); err != nil {
+		log.Error(msgConfigInvalid, "cause", err, "resource", r.Kind)
+		return errors.New(msgConfigInvalid)
+	}
+	return nil
+}
+
+// initGoType initializes the go type of r, and adds its package to imports if needed.
+func (c *Controller) initGoType(r *Resource, imports sets.Set[string]) {
+	if len(r.Group) > 0 && (len(r.Package) == 0 || r.Package == c.Go.Module) {
+		r.GoType = r.Kind
+		return
+	}
+	alias := getAlias(r.Package)
+	r.GoType = alias + "." + r.Kind
+	switch {
+	case r.Cache != CacheMetadataOnly:
+		imports.Insert(alias + ` "` + r.Package + `"`)
+	case r.IsCustom:
+		// the go type of a metadata-only custom resource is only used by the scheme and the client
+		c.MainImports = append(c.MainImports, alias+` "`+r.Package+`"`)
+		if r.Subresources.Status {
+			c.ControllerImports = append(c.ControllerImports, alias+` "`+r.Package+`"`)
+		}
+	}
+}
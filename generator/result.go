@@ -0,0 +1,40 @@
+package generator
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+
+	"github.com/FlyingOnion/pkg/log"
+)
+
+const (
+	msgLegacyDoSync    = `doSync returns an error only; it keeps working, but cannot requeue the key without an error`
+	msgLegacyDoSyncTip = `change doSync to return (Result, error) and regenerate to requeue with Result.Requeue or Result.RequeueAfter`
+)
+
+const eventHandlerFileName = "event_handler.go"
+
+// initSyncResult checks the doSync method in the existing event_handler.go.
+// Before Result was introduced, doSync returned an error only. controller.go keeps calling
+// such a doSync, so that regeneration does not break the code of users.
+func (c *Controller) initSyncResult() {
+	fp := filepath.Join(c.Base, eventHandlerFileName)
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), fp, b, parser.SkipObjectResolution)
+	if err != nil {
+		// reported when the file is updated
+		return
+	}
+	method := "doSync" + c.Resources[0].Kind
+	doSync := findControllerMethod(file, c.Name, method)
+	if doSync == nil || doSync.Type.Results == nil || len(doSync.Type.Results.List) != 1 {
+		return
+	}
+	log.Warn(msgLegacyDoSync, "method", method, "file", fp, "tip", msgLegacyDoSyncTip)
+	c.LegacyDoSync = true
+}
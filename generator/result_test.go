@@ -0,0 +1,219 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// legacyEventHandler is an event_handler.go generated before Result was introduced.
+const legacyEventHandler = `package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var _ *corev1.Pod = nil
+
+func (c *Foo) doSyncPod(ctx context.Context, namespace, name string) error {
+	pod, err := c.podLister.Namespaced(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			utilruntime.HandleError(fmt.Errorf("pod '%s/%s' in work queue does not exists", namespace, name))
+			return nil
+		}
+		return err
+	}
+	klog.Infof("pod %s has been synced", pod.Name)
+	return nil
+}
+`
+
+func TestInitSyncResult(t *testing.T) {
+	tests := []struct {
+		name         string
+		eventHandler string
+		want         bool
+	}{
+		{name: "no event_handler.go"},
+		{name: "legacy doSync", eventHandler: legacyEventHandler, want: true},
+		{
+			name:         "doSync with result",
+			eventHandler: "package main\n\nfunc (c *Foo) doSyncPod(ctx context.Context, namespace, name string) (Result, error) {\n\treturn Result{}, nil\n}\n",
+		},
+		{
+			name:         "doSync of another controller",
+			eventHandler: "package main\n\nfunc (c *Bar) doSyncPod(ctx context.Context, namespace, name string) error {\n\treturn nil\n}\n",
+		},
+		{name: "invalid go file", eventHandler: "package main\n\nfunc (c *Foo) doSyncPod("},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if len(tt.eventHandler) > 0 {
+				writeFiles(t, dir, map[string]string{eventHandlerFileName: tt.eventHandler})
+			}
+			c := &Controller{Name: "Foo", Base: dir, Resources: []Resource{{Kind: "Pod"}}}
+			c.initSyncResult()
+			if c.LegacyDoSync != tt.want {
+				t.Errorf("LegacyDoSync = %v, want %v", c.LegacyDoSync, tt.want)
+			}
+		})
+	}
+}
+
+// resultConfig controls pods.
+const resultConfig = `name: Foo
+go:
+  module: example.com/generated
+resources:
+- kind: Pod
+  isNamespaced: true
+`
+
+// resultTest handles results and errors of syncs with a real workqueue.
+const resultTest = `package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestHandleErr(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Hour, time.Hour))
+	defer queue.ShutDown()
+	c := &Foo{queue: queue, retryOnError: 3, failures: make(map[any]int)}
+	ctx := context.Background()
+
+	c.handleErr(ctx, Result{}, errors.New("failed"), "ns/a")
+	if n := queue.NumRequeues("ns/a"); n != 1 {
+		t.Fatalf("failures of a failed key = %d, want 1", n)
+	}
+	// a key in sync for now forgets its failures, and is added after the delay without counting a failure
+	c.handleErr(ctx, Result{RequeueAfter: 10 * time.Millisecond}, nil, "ns/a")
+	if n := queue.NumRequeues("ns/a"); n != 0 {
+		t.Errorf("failures of a key requeued after a delay = %d, want 0", n)
+	}
+	c.handleErr(ctx, Result{Requeue: true}, nil, "ns/b")
+	if n := queue.NumRequeues("ns/b"); n != 1 {
+		t.Errorf("failures of a requeued key = %d, want 1", n)
+	}
+	c.handleErr(ctx, Result{}, nil, "ns/c")
+	if n := queue.NumRequeues("ns/c"); n != 0 {
+		t.Errorf("failures of a synced key = %d, want 0", n)
+	}
+
+	// only ns/a comes back within the hour of the rate limiter
+	timer := time.AfterFunc(5*time.Second, queue.ShutDown)
+	defer timer.Stop()
+	if key, shutdown := queue.Get(); shutdown || key != "ns/a" {
+		t.Errorf("Get() = %v, %v, want ns/a", key, shutdown)
+	}
+}
+`
+
+// errorClassTest handles errors of each class with a real workqueue.
+const errorClassTest = `package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestErrorClasses(t *testing.T) {
+	if TerminalError(nil) != nil || RequeueError(nil, time.Second) != nil {
+		t.Error("wrapped nil errors must be nil")
+	}
+	errFailed := errors.New("failed")
+	if !errors.Is(TerminalError(errFailed), errFailed) || !errors.Is(RequeueError(errFailed, 0), errFailed) {
+		t.Error("wrapped errors must unwrap")
+	}
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Hour, time.Hour))
+	defer queue.ShutDown()
+	c := &Foo{queue: queue, retryOnError: 1, failures: make(map[any]int)}
+	ctx := context.Background()
+
+	// terminal errors drop the key at once
+	c.handleErr(ctx, Result{}, TerminalError(errFailed), "ns/terminal")
+	if n := queue.NumRequeues("ns/terminal"); n != 0 {
+		t.Errorf("requeues of a terminal error = %d, want 0", n)
+	}
+
+	// requeue errors and conflicts are retried without limit, and do not count as failures
+	for i := 0; i < 3; i++ {
+		c.handleErr(ctx, Result{}, RequeueError(errFailed, 0), "ns/requeue")
+	}
+	c.handleErr(ctx, Result{}, apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "requeue", errFailed), "ns/requeue")
+	c.handleErr(ctx, Result{}, errFailed, "ns/requeue")
+	if n := queue.NumRequeues("ns/requeue"); n != 5 {
+		t.Errorf("requeues = %d, want 5", n)
+	}
+	c.handleErr(ctx, Result{}, RequeueError(errFailed, 10*time.Millisecond), "ns/after")
+	if n := queue.NumRequeues("ns/after"); n != 0 {
+		t.Errorf("rate limited requeues of a delayed requeue error = %d, want 0", n)
+	}
+
+	// unclassified errors are retried retryOnError times, and a success resets them
+	c.handleErr(ctx, Result{}, errFailed, "ns/unclassified")
+	c.handleErr(ctx, Result{}, nil, "ns/unclassified")
+	c.handleErr(ctx, Result{}, errFailed, "ns/unclassified")
+	if n := queue.NumRequeues("ns/unclassified"); n != 1 {
+		t.Errorf("requeues after a success = %d, want 1", n)
+	}
+	c.handleErr(ctx, Result{}, errFailed, "ns/unclassified")
+	if n := queue.NumRequeues("ns/unclassified"); n != 0 {
+		t.Errorf("requeues of a dropped key = %d, want 0", n)
+	}
+
+	want := ErrorCounts{Terminal: 1, Requeue: 5, Unclassified: 4, Dropped: 1}
+	if got := c.ErrorCounts(); got != want {
+		t.Errorf("ErrorCounts() = %+v, want %+v", got, want)
+	}
+
+	// only ns/after comes back within the hour of the rate limiter
+	timer := time.AfterFunc(5*time.Second, queue.ShutDown)
+	defer timer.Stop()
+	if key, shutdown := queue.Get(); shutdown || key != "ns/after" {
+		t.Errorf("Get() = %v, %v, want ns/after", key, shutdown)
+	}
+}
+`
+
+func TestGeneratedSyncResult(t *testing.T) {
+	dir := generateController(t, resultConfig, map[string]string{"result_test.go": resultTest, "errors_test.go": errorClassTest})
+	runGoTest(t, dir)
+}
+
+func TestGeneratedLegacyDoSync(t *testing.T) {
+	skipUnlessGo(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{eventHandlerFileName: legacyEventHandler})
+	generateControllerIn(t, dir, resultConfig, nil)
+	b, err := os.ReadFile(filepath.Join(dir, "controller.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "return Result{}, c.doSyncPod(ctx, namespace, name)"; !strings.Contains(string(b), want) {
+		t.Errorf("missing %q in controller.go", want)
+	}
+	runGoTest(t, dir)
+}
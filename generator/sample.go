@@ -0,0 +1,305 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	msgFailedToGenSample  = `failed to generate sample`
+	msgInvalidSampleValue = `sample value is not valid for the schema; edit it in the sample`
+)
+
+const sampleDir = "config/samples"
+
+// sampleStrings are the placeholders of string formats that are validated by the API server.
+var sampleStrings = map[string]string{
+	"date-time": "2006-01-02T15:04:05Z",
+	"date":      "2006-01-02",
+	"email":     "user@example.com",
+	"uri":       "https://example.com",
+	"ipv4":      "127.0.0.1",
+	"ipv6":      "::1",
+	"hostname":  "example.com",
+}
+
+// sampleFileName returns the path of the sample of r relative to config.Base.
+func sampleFileName(r *Resource) string {
+	return filepath.Join(sampleDir, r.SchemaGroup+"_"+r.Version+"_"+r.LowerKind+".yaml")
+}
+
+// CreateOrUpdateSample generates a sample of each custom resource whose template is generated,
+// with a spec skeleton from the schema of its go type.
+//
+// The sample belongs to the user once created. On regeneration, only the fields
+// that are missing in the sample are added, so edited values are kept.
+func CreateOrUpdateSample(tx *Transaction, config *Controller) error {
+	for _, r := range config.generatedResources() {
+		pkg, err := config.resourcePackage(tx, r.Dir)
+		if err != nil {
+			log.Error("failed to load package", "package", r.Package, "directory", r.Dir, "cause", err)
+			return err
+		}
+		schema, err := rootSchema(pkg, r)
+		if err != nil {
+			log.Error(msgFailedToGenSample, "resource", r.Kind, "version", r.Version, "cause", err)
+			return errors.New(msgFailedToGenSample)
+		}
+		sample := map[string]any{
+			"apiVersion": r.SchemaGroup + "/" + r.Version,
+			"kind":       r.Kind,
+			"metadata":   map[string]any{"name": r.LowerKind + "-sample"},
+		}
+		if spec := schema.Properties["spec"]; spec != nil {
+			sample["spec"] = sampleValue(spec)
+		}
+		// keys are sorted, which happens to be the order of a kubernetes object
+		var cur yaml.Node
+		if err = cur.Encode(sample); err != nil {
+			return err
+		}
+
+		out := &cur
+		fp := filepath.Join(config.Base, sampleFileName(r))
+		b, err := os.ReadFile(fp)
+		switch {
+		case os.IsNotExist(err):
+			log.Info("create sample", "resource", r.Kind, "version", r.Version, "file", fp)
+		case err != nil:
+			log.Error("failed to read file", "file", fp, "cause", err)
+			return err
+		default:
+			var doc yaml.Node
+			if err = yaml.Unmarshal(b, &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+				log.Warn("sample is not a yaml object; skip", "file", fp, "cause", err)
+				continue
+			}
+			if !mergeNode(doc.Content[0], &cur) {
+				log.Info("sample is up to date", "file", fp)
+				continue
+			}
+			log.Info("update sample", "resource", r.Kind, "version", r.Version, "file", fp)
+			out = &doc
+		}
+
+		if spec := schema.Properties["spec"]; spec != nil {
+			root := out
+			if root.Kind == yaml.DocumentNode {
+				root = root.Content[0]
+			}
+			if n := mappingValue(root, "spec"); n != nil {
+				checkSample(r, spec, n, ".spec")
+			}
+		}
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err = enc.Encode(out); err != nil {
+			return err
+		}
+		tx.Add(fp, buf.Bytes())
+	}
+	return nil
+}
+
+// sampleValue returns a placeholder value of schema s.
+// Defaults, enums, minimums and maximums are used if present, and strings match their format or pattern,
+// so that the value is likely to be valid.
+func sampleValue(s *JSONSchema) any {
+	switch {
+	case s.Default != nil:
+		return s.Default
+	case len(s.Enum) > 0:
+		return s.Enum[0]
+	case s.XIntOrString:
+		return 0
+	}
+	switch s.Type {
+	case "object":
+		m := make(map[string]any, len(s.Properties))
+		for name, prop := range s.Properties {
+			m[name] = sampleValue(prop)
+		}
+		return m
+	case "array":
+		if s.Items == nil {
+			return []any{}
+		}
+		return []any{sampleValue(s.Items)}
+	case "string":
+		return sampleString(s)
+	case "integer", "number":
+		var v float64
+		if s.Minimum != nil {
+			v = *s.Minimum
+		} else if s.Maximum != nil && *s.Maximum < 0 {
+			v = *s.Maximum
+		}
+		if s.Type == "integer" {
+			return int64(v)
+		}
+		return v
+	case "boolean":
+		return false
+	}
+	return map[string]any{}
+}
+
+// sampleString returns a placeholder string of schema s, which matches its format or pattern if possible.
+func sampleString(s *JSONSchema) string {
+	const placeholder = "example"
+	if v, ok := sampleStrings[s.Format]; ok {
+		return v
+	}
+	if len(s.Pattern) == 0 {
+		return placeholder
+	}
+	re, err := regexp.Compile(s.Pattern)
+	if err != nil || re.MatchString(placeholder) {
+		return placeholder
+	}
+	parsed, err := syntax.Parse(s.Pattern, syntax.Perl)
+	if err != nil {
+		return placeholder
+	}
+	var b strings.Builder
+	if writeShortestMatch(&b, parsed.Simplify()) && re.MatchString(b.String()) {
+		return b.String()
+	}
+	return placeholder
+}
+
+// writeShortestMatch writes a short string that matches re, preferring letters and digits in character classes.
+// It reports false if re cannot be matched this way, e.g. for back references or empty classes.
+func writeShortestMatch(b *strings.Builder, re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary, syntax.OpStar, syntax.OpQuest:
+		return true
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+		return true
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteByte('a')
+		return true
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return false
+		}
+		for _, c := range "a0A-" {
+			for i := 0; i+1 < len(re.Rune); i += 2 {
+				if re.Rune[i] <= c && c <= re.Rune[i+1] {
+					b.WriteRune(c)
+					return true
+				}
+			}
+		}
+		b.WriteRune(re.Rune[0])
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return writeShortestMatch(b, re.Sub[0])
+	case syntax.OpRepeat:
+		for i := 0; i < re.Min; i++ {
+			if !writeShortestMatch(b, re.Sub[0]) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !writeShortestMatch(b, sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		return writeShortestMatch(b, re.Sub[0])
+	}
+	return false
+}
+
+// checkSample warns about the values of sample node n at path that do not pass the markers of schema s.
+func checkSample(r *Resource, s *JSONSchema, n *yaml.Node, path string) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if prop := s.Properties[n.Content[i].Value]; prop != nil {
+				checkSample(r, prop, n.Content[i+1], path+"."+n.Content[i].Value)
+			}
+		}
+		return
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range n.Content {
+				checkSample(r, s.Items, item, path+"["+strconv.Itoa(i)+"]")
+			}
+		}
+		return
+	case yaml.ScalarNode:
+	default:
+		return
+	}
+	var v any
+	if err := n.Decode(&v); err != nil {
+		return
+	}
+	// numbers are checked as JSON numbers
+	switch x := v.(type) {
+	case int:
+		v = float64(x)
+	case uint64:
+		v = float64(x)
+	}
+	m := Markers{Minimum: s.Minimum, Maximum: s.Maximum, Pattern: s.Pattern, Enum: s.Enum}
+	if err := m.check(v); err != nil {
+		log.Warn(msgInvalidSampleValue, "resource", r.Kind, "version", r.Version, "field", path, "cause", err)
+	}
+}
+
+// mergeNode adds the keys of mapping src that are missing in mapping dst, recursively.
+// Items of a sequence are merged with the first item of src.
+// It reports whether dst is changed.
+func mergeNode(dst, src *yaml.Node) bool {
+	changed := false
+	if dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode && len(src.Content) > 0 {
+		for _, item := range dst.Content {
+			changed = mergeNode(item, src.Content[0]) || changed
+		}
+		return changed
+	}
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		if existing := mappingValue(dst, key.Value); existing != nil {
+			changed = mergeNode(existing, value) || changed
+			continue
+		}
+		// an empty object like "spec: {}" is a flow mapping, which would keep new keys on one line
+		dst.Style &^= yaml.FlowStyle
+		dst.Content = append(dst.Content, key, value)
+		changed = true
+	}
+	return changed
+}
+
+// mappingValue returns the value of key in mapping n, or nil if not found.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
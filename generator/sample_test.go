@@ -0,0 +1,130 @@
+package generator
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSampleString(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema JSONSchema
+		want   string
+	}{
+		{name: "no pattern", schema: JSONSchema{Type: "string"}, want: "example"},
+		{name: "format", schema: JSONSchema{Type: "string", Format: "email"}, want: "user@example.com"},
+		{name: "placeholder matches", schema: JSONSchema{Type: "string", Pattern: "^[a-z]+$"}, want: "example"},
+		{name: "character classes", schema: JSONSchema{Type: "string", Pattern: "^[A-Z]{3}-[0-9]{2}$"}, want: "AAA-00"},
+		{name: "alternation", schema: JSONSchema{Type: "string", Pattern: "^(Always|Never)$"}, want: "Always"},
+		{name: "optional and repeated", schema: JSONSchema{Type: "string", Pattern: `^v[0-9]+(\.[0-9]+)?$`}, want: "v0"},
+		{name: "unanchored", schema: JSONSchema{Type: "string", Pattern: "[0-9]"}, want: "0"},
+		{name: "never matches", schema: JSONSchema{Type: "string", Pattern: `^a\bb$`}, want: "example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sampleString(&tt.schema)
+			if got != tt.want {
+				t.Errorf("sampleString() = %q, want %q", got, tt.want)
+			}
+			if got != "example" && len(tt.schema.Pattern) > 0 && !regexp.MustCompile(tt.schema.Pattern).MatchString(got) {
+				t.Errorf("%q does not match %q", got, tt.schema.Pattern)
+			}
+		})
+	}
+}
+
+func TestSampleValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema JSONSchema
+		want   any
+	}{
+		{name: "default", schema: JSONSchema{Type: "integer", Minimum: ptr(1.0), Default: 3.0}, want: 3.0},
+		{name: "enum", schema: JSONSchema{Type: "string", Enum: []any{"Always", "Never"}}, want: "Always"},
+		{name: "integer", schema: JSONSchema{Type: "integer"}, want: int64(0)},
+		{name: "integer with minimum", schema: JSONSchema{Type: "integer", Minimum: ptr(1.0)}, want: int64(1)},
+		{name: "integer with negative maximum", schema: JSONSchema{Type: "integer", Maximum: ptr(-2.0)}, want: int64(-2)},
+		{name: "number with positive maximum", schema: JSONSchema{Type: "number", Maximum: ptr(2.0)}, want: 0.0},
+		{name: "boolean", schema: JSONSchema{Type: "boolean"}, want: false},
+		{name: "string", schema: JSONSchema{Type: "string"}, want: "example"},
+		{name: "int or string", schema: JSONSchema{XIntOrString: true}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sampleValue(&tt.schema); got != tt.want {
+				t.Errorf("sampleValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeNode(t *testing.T) {
+	tests := []struct {
+		name    string
+		dst     string
+		src     string
+		want    string
+		changed bool
+	}{
+		{
+			name:    "new field into empty flow mapping",
+			dst:     "spec: {}\n",
+			src:     "spec:\n  replicas: 1\n",
+			want:    "spec:\n  replicas: 1\n",
+			changed: true,
+		},
+		{
+			name: "user values are kept",
+			dst:  "spec:\n  replicas: 3\n",
+			src:  "spec:\n  replicas: 1\n",
+			want: "spec:\n  replicas: 3\n",
+		},
+		{
+			name:    "new field is appended",
+			dst:     "spec:\n  replicas: 3 # user\n",
+			src:     "spec:\n  replicas: 1\n  paused: false\n",
+			want:    "spec:\n  replicas: 3 # user\n  paused: false\n",
+			changed: true,
+		},
+		{
+			name:    "items of a list",
+			dst:     "spec:\n  items:\n    - name: a\n    - name: b\n",
+			src:     "spec:\n  items:\n    - name: x\n      size: 0\n",
+			want:    "spec:\n  items:\n    - name: a\n      size: 0\n    - name: b\n      size: 0\n",
+			changed: true,
+		},
+		{
+			name: "different kinds are not merged",
+			dst:  "spec:\n  items: none\n",
+			src:  "spec:\n  items:\n    a: 1\n",
+			want: "spec:\n  items: none\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst, src yaml.Node
+			if err := yaml.Unmarshal([]byte(tt.dst), &dst); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.src), &src); err != nil {
+				t.Fatal(err)
+			}
+			changed := mergeNode(dst.Content[0], src.Content[0])
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			var b strings.Builder
+			enc := yaml.NewEncoder(&b)
+			enc.SetIndent(2)
+			if err := enc.Encode(&dst); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	msgSubresourcesOfBuiltin     = `subresources and printerColumns are only supported by custom resources`
+	msgInvalidSpecReplicasPath   = `specReplicasPath must start with ".spec."`
+	msgInvalidStatusReplicasPath = `statusReplicasPath must start with ".status."`
+	msgInvalidLabelSelectorPath  = `labelSelectorPath must start with ".spec." or ".status."`
+	msgNoPrinterColumnName       = `printer column must have a name`
+	msgDuplicatePrinterColumn    = `duplicate printer column`
+	msgInvalidPrinterColumnType  = `printer column type must be one of integer, number, string, boolean, date`
+	msgInvalidPrinterColumnPath  = `printer column jsonPath must start with "."`
+	msgScalePathNotFound         = `scale path is not found in the schema; add the field to the go type`
+	msgScalePathWrongType        = `scale path has a wrong type`
+	msgNoStatusField             = `status subresource is enabled but the resource has no status field`
+	msgPrinterColumnPathNotFound = `printer column jsonPath is not found in the schema`
+)
+
+// Subresources are the subresources of a custom resource.
+//
+//	subresources:
+//	  status: true
+//	  scale:
+//	    specReplicasPath: .spec.replicas
+//	    statusReplicasPath: .status.replicas
+type Subresources struct {
+	// Status enables the /status subresource.
+	// The controller gets an update<Kind>Status helper that updates the status through it.
+	Status bool              `yaml:"status"`
+	Scale  *ScaleSubresource `yaml:"scale,omitempty"`
+}
+
+// ScaleSubresource is the /scale subresource of a custom resource.
+type ScaleSubresource struct {
+	SpecReplicasPath   string `yaml:"specReplicasPath"`
+	StatusReplicasPath string `yaml:"statusReplicasPath"`
+	LabelSelectorPath  string `yaml:"labelSelectorPath,omitempty"`
+}
+
+// PrinterColumn is an additional column shown by "kubectl get".
+type PrinterColumn struct {
+	Name        string `yaml:"name"`
+	Type        string `yaml:"type"`
+	Format      string `yaml:"format,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	Priority    int32  `yaml:"priority,omitempty"`
+	JSONPath    string `yaml:"jsonPath"`
+}
+
+// CRDSubresources are the subresources in a CRD version.
+type CRDSubresources struct {
+	Status *struct{}         `yaml:"status,omitempty"`
+	Scale  *ScaleSubresource `yaml:"scale,omitempty"`
+}
+
+var printerColumnTypes = sets.New("integer", "number", "string", "boolean", "date")
+
+// validateSubresources checks the subresources and the printer columns of r.
+func (r *Resource) validateSubresources() error {
+	if !r.IsCustom && (r.Subresources.Status || r.Subresources.Scale != nil || len(r.PrinterColumns) > 0) {
+		return errors.New(msgSubresourcesOfBuiltin)
+	}
+	if s := r.Subresources.Scale; s != nil {
+		if !strings.HasPrefix(s.SpecReplicasPath, ".spec.") {
+			return fmt.Errorf("%s: %q", msgInvalidSpecReplicasPath, s.SpecReplicasPath)
+		}
+		if !strings.HasPrefix(s.StatusReplicasPath, ".status.") {
+			return fmt.Errorf("%s: %q", msgInvalidStatusReplicasPath, s.StatusReplicasPath)
+		}
+		if len(s.LabelSelectorPath) > 0 && !strings.HasPrefix(s.LabelSelectorPath, ".spec.") && !strings.HasPrefix(s.LabelSelectorPath, ".status.") {
+			return fmt.Errorf("%s: %q", msgInvalidLabelSelectorPath, s.LabelSelectorPath)
+		}
+	}
+	names := sets.New[string]()
+	for _, col := range r.PrinterColumns {
+		switch {
+		case len(col.Name) == 0:
+			return errors.New(msgNoPrinterColumnName)
+		case names.Has(col.Name):
+			return fmt.Errorf("%s: %s", msgDuplicatePrinterColumn, col.Name)
+		case !printerColumnTypes.Has(col.Type):
+			return fmt.Errorf("%s: %s", msgInvalidPrinterColumnType, col.Type)
+		case !strings.HasPrefix(col.JSONPath, "."):
+			return fmt.Errorf("%s: %s", msgInvalidPrinterColumnPath, col.JSONPath)
+		}
+		names.Insert(col.Name)
+	}
+	return nil
+}
+
+// crdSubresources returns the subresources of r in its CRD, or nil if there is none.
+func (r *Resource) crdSubresources() *CRDSubresources {
+	if !r.Subresources.Status && r.Subresources.Scale == nil {
+		return nil
+	}
+	s := &CRDSubresources{Scale: r.Subresources.Scale}
+	if r.Subresources.Status {
+		s.Status = &struct{}{}
+	}
+	return s
+}
+
+// checkSubresourcePaths checks that the paths of subresources and printer columns of r exist in schema.
+//
+// Replica paths must point to integers, and the label selector path must point to a string.
+// A path of a wrong type is an error. A missing path is only warned about, like printer columns,
+// as a definition that is just created has an empty spec and status for the user to fill in.
+func (r *Resource) checkSubresourcePaths(schema *JSONSchema) error {
+	if s := r.Subresources.Scale; s != nil {
+		for _, p := range []struct{ path, typ string }{
+			{s.SpecReplicasPath, "integer"},
+			{s.StatusReplicasPath, "integer"},
+			{s.LabelSelectorPath, "string"},
+		} {
+			if len(p.path) == 0 {
+				continue
+			}
+			found := schema.lookup(p.path)
+			switch {
+			// lookup stops at objects without properties, e.g. an empty spec
+			case r.definitionCreated || found == nil || (found.Type == "object" && found.Properties == nil):
+				log.Warn(msgScalePathNotFound, "resource", r.Kind, "version", r.Version, "path", p.path, "type", p.typ)
+			case found.Type != p.typ:
+				return fmt.Errorf("%s: %s (%s, got %s)", msgScalePathWrongType, p.path, p.typ, found.Type)
+			}
+		}
+	}
+	if r.Subresources.Status && schema.lookup(".status") == nil {
+		log.Warn(msgNoStatusField, "resource", r.Kind)
+	}
+	for _, col := range r.PrinterColumns {
+		if schema.lookup(col.JSONPath) == nil {
+			log.Warn(msgPrinterColumnPathNotFound, "resource", r.Kind, "column", col.Name, "jsonPath", col.JSONPath)
+		}
+	}
+	return nil
+}
+
+// lookup returns the schema of a simple JSONPath like ".spec.replicas", or nil if not found.
+// If the path goes into an object without properties (e.g. metadata), the object is returned.
+func (s *JSONSchema) lookup(path string) *JSONSchema {
+	for _, name := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		if s == nil {
+			return nil
+		}
+		if s.Properties == nil {
+			if s.Type == "object" && s.AdditionalProperties == nil {
+				return s
+			}
+			return nil
+		}
+		s = s.Properties[name]
+	}
+	return s
+}
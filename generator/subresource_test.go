@@ -0,0 +1,132 @@
+package generator
+
+import "testing"
+
+func TestValidateSubresources(t *testing.T) {
+	scale := &ScaleSubresource{SpecReplicasPath: ".spec.replicas", StatusReplicasPath: ".status.replicas"}
+	tests := []struct {
+		name    string
+		r       Resource
+		wantErr bool
+	}{
+		{name: "builtin without subresources", r: Resource{Kind: "Pod"}},
+		{name: "builtin with status", r: Resource{Kind: "Pod", Subresources: Subresources{Status: true}}, wantErr: true},
+		{name: "scale", r: Resource{Kind: "Foo", IsCustom: true, Subresources: Subresources{Status: true, Scale: scale}}},
+		{
+			name:    "spec replicas outside spec",
+			r:       Resource{Kind: "Foo", IsCustom: true, Subresources: Subresources{Scale: &ScaleSubresource{SpecReplicasPath: ".status.replicas", StatusReplicasPath: ".status.replicas"}}},
+			wantErr: true,
+		},
+		{
+			name:    "label selector outside spec and status",
+			r:       Resource{Kind: "Foo", IsCustom: true, Subresources: Subresources{Scale: &ScaleSubresource{SpecReplicasPath: ".spec.replicas", StatusReplicasPath: ".status.replicas", LabelSelectorPath: ".metadata.labels"}}},
+			wantErr: true,
+		},
+		{
+			name: "printer columns",
+			r: Resource{Kind: "Foo", IsCustom: true, PrinterColumns: []PrinterColumn{
+				{Name: "Replicas", Type: "integer", JSONPath: ".spec.replicas"},
+				{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
+			}},
+		},
+		{
+			name:    "duplicate printer column",
+			r:       Resource{Kind: "Foo", IsCustom: true, PrinterColumns: []PrinterColumn{{Name: "Age", Type: "date", JSONPath: ".a"}, {Name: "Age", Type: "date", JSONPath: ".b"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid printer column type",
+			r:       Resource{Kind: "Foo", IsCustom: true, PrinterColumns: []PrinterColumn{{Name: "Age", Type: "time", JSONPath: ".a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "printer column path without dot",
+			r:       Resource{Kind: "Foo", IsCustom: true, PrinterColumns: []PrinterColumn{{Name: "Age", Type: "date", JSONPath: "metadata"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.r.validateSubresources(); (err != nil) != tt.wantErr {
+				t.Errorf("validateSubresources() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaLookup(t *testing.T) {
+	schema := &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"metadata": {Type: "object"},
+			"spec": {
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"replicas": {Type: "integer"},
+					"labels":   {Type: "object", AdditionalProperties: &JSONSchema{Type: "string"}},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: ".spec.replicas", want: "integer"},
+		{path: ".spec", want: "object"},
+		// objects without properties stop the lookup
+		{path: ".metadata.name", want: "object"},
+		{path: ".spec.missing", want: ""},
+		{path: ".spec.replicas.value", want: ""},
+		{path: ".spec.labels.app", want: ""},
+		{path: ".status.replicas", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := ""
+			if s := schema.lookup(tt.path); s != nil {
+				got = s.Type
+			}
+			if got != tt.want {
+				t.Errorf("lookup(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSubresourcePaths(t *testing.T) {
+	schema := &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"spec": {
+				Type:       "object",
+				Properties: map[string]*JSONSchema{"replicas": {Type: "integer"}, "selector": {Type: "string"}},
+			},
+			"status": {Type: "object"},
+		},
+	}
+	tests := []struct {
+		name    string
+		scale   ScaleSubresource
+		created bool
+		wantErr bool
+	}{
+		{name: "paths found", scale: ScaleSubresource{SpecReplicasPath: ".spec.replicas", LabelSelectorPath: ".spec.selector"}},
+		// missing paths are warned about
+		{name: "missing path", scale: ScaleSubresource{SpecReplicasPath: ".spec.size"}},
+		{name: "object without properties", scale: ScaleSubresource{StatusReplicasPath: ".status.replicas"}},
+		{name: "wrong type", scale: ScaleSubresource{SpecReplicasPath: ".spec.selector"}, wantErr: true},
+		{name: "wrong type of selector", scale: ScaleSubresource{LabelSelectorPath: ".spec.replicas"}, wantErr: true},
+		{name: "definition just created", scale: ScaleSubresource{SpecReplicasPath: ".spec.selector"}, created: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Resource{Kind: "Foo", Version: "v1", definitionCreated: tt.created}
+			r.Subresources.Scale = &tt.scale
+			err := r.checkSubresourcePaths(schema)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkSubresourcePaths() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package generator
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+)
+
+// Transaction collects rendered files and writes them all at once.
+//
+// Each file is written to a temporary file in the same directory and renamed to its target,
+// so a file is either fully written or untouched.
+// If any write fails, every file written so far is rolled back to its previous content.
+type Transaction struct {
+	files []stagedFile
+	index map[string]int
+
+	// states of the files and directories touched by Commit, used by Rollback
+	written []previousState
+	dirs    []string
+
+	// snapshot holds the backup of the files before Commit; it is discarded by Rollback
+	snapshot *snapshotWriter
+}
+
+type stagedFile struct {
+	path    string
+	content []byte
+	remove  bool
+	track   bool
+}
+
+type previousState struct {
+	path    string
+	existed bool
+	content []byte
+	mode    os.FileMode
+}
+
+func NewTransaction() *Transaction {
+	return &Transaction{index: make(map[string]int)}
+}
+
+// Add stages content for path. A later Add on the same path replaces the earlier one.
+func (t *Transaction) Add(path string, content []byte) {
+	path = filepath.Clean(path)
+	if i, ok := t.index[path]; ok {
+		t.files[i].content = content
+		return
+	}
+	t.index[path] = len(t.files)
+	t.files = append(t.files, stagedFile{path: path, content: content})
+}
+
+// Remove stages the removal of path.
+func (t *Transaction) Remove(path string) {
+	path = filepath.Clean(path)
+	if i, ok := t.index[path]; ok {
+		t.files[i] = stagedFile{path: path, remove: true}
+		return
+	}
+	t.index[path] = len(t.files)
+	t.files = append(t.files, stagedFile{path: path, remove: true})
+}
+
+// Track records the current content of path at commit time, without writing it,
+// so that changes made to it outside the transaction (e.g. by post-generation steps)
+// are rolled back too.
+func (t *Transaction) Track(path string) {
+	path = filepath.Clean(path)
+	if _, ok := t.index[path]; ok {
+		return
+	}
+	t.index[path] = len(t.files)
+	t.files = append(t.files, stagedFile{path: path, track: true})
+}
+
+// contentOf returns the content staged for path, or the current content on disk if path is not staged.
+func (t *Transaction) contentOf(path string) ([]byte, error) {
+	path = filepath.Clean(path)
+	if i, ok := t.index[path]; ok && !t.files[i].track {
+		return t.files[i].content, nil
+	}
+	return os.ReadFile(path)
+}
+
+// goFiles returns the paths of the go files staged for writing.
+func (t *Transaction) goFiles() []string {
+	var paths []string
+	for _, f := range t.files {
+		if !f.track && !f.remove && strings.HasSuffix(f.path, ".go") {
+			paths = append(paths, f.path)
+		}
+	}
+	return paths
+}
+
+// Rewrite writes content to path right away, e.g. in post-generation steps after Commit.
+// The previous content of path is restored by Rollback.
+func (t *Transaction) Rewrite(path string, content []byte) error {
+	path = filepath.Clean(path)
+	state, err := readState(path)
+	if err != nil {
+		return err
+	}
+	// files in the transaction are already in the snapshot with their content before Commit
+	if _, ok := t.index[path]; !ok {
+		if t.snapshot != nil {
+			if err = t.snapshot.add(state); err != nil {
+				return err
+			}
+		}
+		t.index[path] = len(t.files)
+		t.files = append(t.files, stagedFile{path: path, track: true})
+	}
+	if err = writeFileAtomic(path, content, state.mode); err != nil {
+		return err
+	}
+	t.written = append(t.written, state)
+	return nil
+}
+
+// pending returns the current states of the staged files that Commit will change.
+// Tracked files are included if any other file will change.
+func (t *Transaction) pending() ([]previousState, error) {
+	var changed, tracked []previousState
+	for _, f := range t.files {
+		state, err := readState(f.path)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case f.track:
+			tracked = append(tracked, state)
+		case f.remove:
+			if state.existed {
+				changed = append(changed, state)
+			}
+		case !state.existed || string(state.content) != string(f.content):
+			changed = append(changed, state)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	return append(changed, tracked...), nil
+}
+
+// StagedInDir returns the content of files staged for writing in dir, by path.
+// Files staged for removal have empty content.
+func (t *Transaction) StagedInDir(dir string) map[string][]byte {
+	dir = filepath.Clean(dir)
+	staged := make(map[string][]byte)
+	for _, f := range t.files {
+		if f.track || filepath.Dir(f.path) != dir {
+			continue
+		}
+		staged[f.path] = f.content
+	}
+	return staged
+}
+
+// Commit writes all staged files. On failure, it rolls back and returns the error.
+func (t *Transaction) Commit() error {
+	for _, f := range t.files {
+		if err := t.write(f); err != nil {
+			log.Error("failed to write file", "file", f.path, "cause", err)
+			if rbErr := t.Rollback(); rbErr != nil {
+				return errors.Join(err, rbErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// readState reads the current content and mode of path.
+func readState(path string) (previousState, error) {
+	state := previousState{path: path, mode: 0644}
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		state.existed, state.mode = true, info.Mode().Perm()
+		if state.content, err = os.ReadFile(path); err != nil {
+			return state, err
+		}
+	case !os.IsNotExist(err):
+		return state, err
+	}
+	return state, nil
+}
+
+func (t *Transaction) write(f stagedFile) error {
+	state, err := readState(f.path)
+	if err != nil {
+		return err
+	}
+
+	if f.track {
+		t.written = append(t.written, state)
+		return nil
+	}
+	if f.remove {
+		if !state.existed {
+			return nil
+		}
+		if err = os.Remove(f.path); err != nil {
+			return err
+		}
+		t.written = append(t.written, state)
+		return nil
+	}
+	if err = t.mkdirAll(filepath.Dir(f.path)); err != nil {
+		return err
+	}
+	if state.existed && string(state.content) == string(f.content) {
+		return nil
+	}
+	if err = writeFileAtomic(f.path, f.content, state.mode); err != nil {
+		return err
+	}
+	t.written = append(t.written, state)
+	return nil
+}
+
+// mkdirAll creates dir and records every directory it creates.
+func (t *Transaction) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], os.ModePerm); err != nil {
+			return err
+		}
+		log.Info("create directory", "directory", missing[i])
+		t.dirs = append(t.dirs, missing[i])
+	}
+	return nil
+}
+
+// Rollback restores every file written by Commit to its previous content,
+// and removes the files and directories that did not exist before.
+// The snapshot of the transaction is removed if every file is restored.
+func (t *Transaction) Rollback() error {
+	var errs []error
+	for i := len(t.written) - 1; i >= 0; i-- {
+		s := t.written[i]
+		log.Warn("roll back file", "file", s.path)
+		var err error
+		if s.existed {
+			err = writeFileAtomic(s.path, s.content, s.mode)
+		} else {
+			err = os.Remove(s.path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("failed to roll back file", "file", s.path, "cause", err)
+			errs = append(errs, err)
+		}
+	}
+	for i := len(t.dirs) - 1; i >= 0; i-- {
+		// directories that are not empty now (e.g. written by post-generation steps) are kept
+		if err := os.Remove(t.dirs[i]); err == nil {
+			log.Warn("remove directory", "directory", t.dirs[i])
+		}
+	}
+	t.written, t.dirs = nil, nil
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if t.snapshot != nil {
+		t.snapshot.discard()
+		t.snapshot = nil
+	}
+	return nil
+}
+
+// writeFileAtomic writes content to a temporary file in the directory of path,
+// then renames it to path.
+func writeFileAtomic(path string, content []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
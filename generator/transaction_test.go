@@ -0,0 +1,179 @@
+package generator
+
+import (
+	"go/format"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes files by path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		fp := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fp), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFiles checks the content of files by path relative to dir.
+// An empty content means that the file must not exist.
+func checkFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, want := range files {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		switch {
+		case len(want) == 0 && !os.IsNotExist(err):
+			t.Errorf("%s exists: %q", name, b)
+		case len(want) == 0:
+		case err != nil:
+			t.Errorf("%s: %v", name, err)
+		case string(b) != want:
+			t.Errorf("%s = %q, want %q", name, b, want)
+		}
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":   "old main",
+		"stale.go":  "stale",
+		"same.go":   "same",
+		"custom.go": "custom",
+	})
+	tx := NewTransaction()
+	tx.Add(filepath.Join(dir, "main.go"), []byte("new main"))
+	tx.Add(filepath.Join(dir, "api", "v1", "types.go"), []byte("types"))
+	tx.Add(filepath.Join(dir, "same.go"), []byte("same"))
+	tx.Remove(filepath.Join(dir, "stale.go"))
+	tx.Remove(filepath.Join(dir, "missing.go"))
+	tx.Track(filepath.Join(dir, "custom.go"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, dir, map[string]string{
+		"main.go":         "new main",
+		"api/v1/types.go": "types",
+		"same.go":         "same",
+		"stale.go":        "",
+		"custom.go":       "custom",
+	})
+	// files not changed by Commit are not rolled back
+	for _, s := range tx.written {
+		if filepath.Base(s.path) == "same.go" || filepath.Base(s.path) == "missing.go" {
+			t.Errorf("%s is recorded as written", s.path)
+		}
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// stage stages files of the transaction in dir
+		stage func(tx *Transaction, dir string)
+		// after runs after a successful Commit, e.g. as a post-generation step
+		after func(t *testing.T, tx *Transaction, dir string)
+		// commitFails means Commit fails and rolls back by itself
+		commitFails bool
+	}{
+		{
+			name: "failed write",
+			stage: func(tx *Transaction, dir string) {
+				tx.Add(filepath.Join(dir, "main.go"), []byte("new main"))
+				tx.Add(filepath.Join(dir, "api", "v1", "types.go"), []byte("types"))
+				tx.Remove(filepath.Join(dir, "stale.go"))
+				// the parent of the file is a regular file
+				tx.Add(filepath.Join(dir, "blocker", "file.go"), []byte("never written"))
+			},
+			commitFails: true,
+		},
+		{
+			name: "tracked file changed after commit",
+			stage: func(tx *Transaction, dir string) {
+				tx.Add(filepath.Join(dir, "main.go"), []byte("new main"))
+				tx.Track(filepath.Join(dir, "go.sum"))
+			},
+			after: func(t *testing.T, tx *Transaction, dir string) {
+				writeFiles(t, dir, map[string]string{"go.sum": "tidied"})
+			},
+		},
+		{
+			name: "files rewritten by post-generation steps",
+			stage: func(tx *Transaction, dir string) {
+				tx.Add(filepath.Join(dir, "main.go"), []byte("package main\nfunc  main() {}\n"))
+				tx.Add(filepath.Join(dir, "api", "v1", "types.go"), []byte("package v1\n"))
+			},
+			after: func(t *testing.T, tx *Transaction, dir string) {
+				if err := rewriteGoFiles(tx, format.Source); err != nil {
+					t.Fatal(err)
+				}
+				checkFiles(t, dir, map[string]string{
+					"main.go":         "package main\n\nfunc main() {}\n",
+					"api/v1/types.go": "package v1\n",
+					// files not staged in the transaction are not rewritten
+					"user.go": "package main\nfunc  user() {}\n",
+				})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			before := map[string]string{
+				"main.go":  "old main",
+				"stale.go": "stale",
+				"go.sum":   "sum",
+				"blocker":  "not a directory",
+				"user.go":  "package main\nfunc  user() {}\n",
+			}
+			writeFiles(t, dir, before)
+			tx := NewTransaction()
+			tt.stage(tx, dir)
+			err := tx.Commit()
+			if tt.commitFails != (err != nil) {
+				t.Fatalf("Commit() = %v, want failure %v", err, tt.commitFails)
+			}
+			if !tt.commitFails {
+				tt.after(t, tx, dir)
+				if err = tx.Rollback(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkFiles(t, dir, before)
+			checkFiles(t, dir, map[string]string{"api/v1/types.go": ""})
+			if _, err = os.Stat(filepath.Join(dir, "api")); !os.IsNotExist(err) {
+				t.Errorf("directory created by Commit is not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestTransactionRollbackKeepsMode(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(fp, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tx := NewTransaction()
+	tx.Add(fp, []byte("new"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0755))
+	}
+	checkFiles(t, dir, map[string]string{"run.sh": "old"})
+}
@@ -0,0 +1,414 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/FlyingOnion/pkg/log"
+	"golang.org/x/mod/modfile"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	msgNoGoFiles          = `no go files in package`
+	msgStubbedPackages    = `some imported packages could not be loaded from the module cache and are stubbed`
+	msgStubbedPackagesTip = `run "go mod download" and koolbuilder again for exact deepcopy code`
+)
+
+// knownShallowTypes are well-known types of stubbed packages that can be copied by assignment.
+var knownShallowTypes = sets.New(
+	"k8s.io/apimachinery/pkg/apis/meta/v1.TypeMeta",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.ConditionStatus",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.DeletionPropagation",
+	"k8s.io/apimachinery/pkg/types.UID",
+	"k8s.io/apimachinery/pkg/types.NamespacedName",
+	"k8s.io/apimachinery/pkg/types.PatchType",
+	"k8s.io/apimachinery/pkg/util/intstr.IntOrString",
+)
+
+// knownDeepCopyTypes are well-known types of stubbed packages that implement DeepCopyInto.
+// The kind of other types of stubbed packages is unknown, so they are copied by assignment,
+// which compiles whatever they are.
+var knownDeepCopyTypes = sets.New(
+	"k8s.io/apimachinery/pkg/api/resource.Quantity",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.Condition",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.Duration",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.LabelSelector",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.LabelSelectorRequirement",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.MicroTime",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.OwnerReference",
+	"k8s.io/apimachinery/pkg/apis/meta/v1.Time",
+	"k8s.io/apimachinery/pkg/runtime.RawExtension",
+)
+
+// loadedPackage is a type-checked package of custom resources.
+type loadedPackage struct {
+	types *types.Package
+	// files are the parsed files of the package, by path
+	files map[string]*ast.File
+	fset  *token.FileSet
+	// stubs is the set of import paths that could not be loaded and were stubbed
+	stubs sets.Set[string]
+	// docs are the doc comments of types and fields, by the position of their names
+	docs map[token.Pos]*ast.CommentGroup
+	// markers are the parsed validation markers, by type or field
+	markers map[types.Object]Markers
+}
+
+// doc returns the doc comment of a type or field declared in the package.
+func (p *loadedPackage) doc(obj types.Object) *ast.CommentGroup {
+	return p.docs[obj.Pos()]
+}
+
+// markersOf returns the validation markers of a type or field declared in the package.
+func (p *loadedPackage) markersOf(obj types.Object) (Markers, error) {
+	if m, ok := p.markers[obj]; ok {
+		return m, nil
+	}
+	if obj.Pkg() != p.types {
+		return Markers{}, nil
+	}
+	m, err := parseMarkers(p.doc(obj))
+	if err != nil {
+		return m, fmt.Errorf("%s: %w", obj.Name(), err)
+	}
+	p.markers[obj] = m
+	return m, nil
+}
+
+// resourcePackage loads the package in dir, including files staged in tx, and caches it.
+// Generated files are excluded, since they are regenerated.
+func (c *Controller) resourcePackage(tx *Transaction, dir string) (*loadedPackage, error) {
+	if pkg, ok := c.packages[dir]; ok {
+		return pkg, nil
+	}
+	overlay := make(map[string][]byte)
+	for _, fp := range generatedFilesInDir(dir) {
+		overlay[fp] = nil
+	}
+	for fp, b := range tx.StagedInDir(dir) {
+		if !isGeneratedFile(fp) {
+			overlay[fp] = b
+		}
+	}
+	// go.mod may not be written yet
+	goMod, _ := tx.contentOf(filepath.Join(c.Base, "go.mod"))
+	pkg, err := loadPackage(dir, overlay, goMod)
+	if err != nil {
+		return nil, err
+	}
+	if c.packages == nil {
+		c.packages = make(map[string]*loadedPackage)
+	}
+	c.packages[dir] = pkg
+	return pkg, nil
+}
+
+// loadPackage type-checks the go package in dir.
+//
+// Files in overlay replace or add files on disk; files with empty content are excluded.
+// Imported packages are loaded from export data listed by "go list -export".
+// Packages that cannot be listed in dir, e.g. because go.mod is not written yet,
+// are listed again in a temporary module with goMod, the go.mod to be written.
+// Packages that are still not found, e.g. because dependencies are not downloaded,
+// are replaced by stubs.
+func loadPackage(dir string, overlay map[string][]byte, goMod []byte) (*loadedPackage, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[string][]byte)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error("failed to read directory", "directory", dir, "cause", err)
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			log.Error("failed to read file", "file", filepath.Join(dir, name), "cause", err)
+			return nil, err
+		}
+		sources[filepath.Join(dir, name)] = b
+	}
+	for p, b := range overlay {
+		if p, err = filepath.Abs(p); err != nil {
+			return nil, err
+		}
+		if filepath.Dir(p) == dir && strings.HasSuffix(p, ".go") {
+			sources[p] = b
+		}
+	}
+
+	pkg := &loadedPackage{
+		files:   make(map[string]*ast.File, len(sources)),
+		fset:    token.NewFileSet(),
+		docs:    make(map[token.Pos]*ast.CommentGroup),
+		markers: make(map[types.Object]Markers),
+	}
+	files := make([]*ast.File, 0, len(sources))
+	for p, b := range sources {
+		if len(b) == 0 {
+			continue
+		}
+		f, err := parser.ParseFile(pkg.fset, p, b, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			log.Error("failed to parse file", "file", p, "cause", err)
+			return nil, err
+		}
+		pkg.files[p] = f
+		files = append(files, f)
+		collectDocs(f, pkg.docs)
+	}
+	if len(files) == 0 {
+		return nil, errors.New(msgNoGoFiles)
+	}
+
+	imp := newPackageImporter(pkg.fset, dir, files, goMod)
+	conf := types.Config{
+		Importer: imp,
+		// errors are expected, e.g. resources that do not implement runtime.Object before generation
+		Error: func(error) {},
+	}
+	pkg.types, _ = conf.Check(files[0].Name.Name, pkg.fset, files, nil)
+	pkg.stubs = imp.stubs
+	if imp.stubs.Len() > 0 {
+		log.Warn(msgStubbedPackages, "packages", strings.Join(sets.List(imp.stubs), ", "), "tip", msgStubbedPackagesTip)
+	}
+	return pkg, nil
+}
+
+// collectDocs collects the doc comments of the types and fields declared in f.
+// A field without doc comment takes its line comment.
+func collectDocs(f *ast.File, docs map[token.Pos]*ast.CommentGroup) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GenDecl:
+			if n.Tok == token.TYPE && len(n.Specs) == 1 && n.Doc != nil {
+				docs[n.Specs[0].(*ast.TypeSpec).Name.Pos()] = n.Doc
+			}
+		case *ast.TypeSpec:
+			if n.Doc != nil {
+				docs[n.Name.Pos()] = n.Doc
+			}
+		case *ast.Field:
+			doc := n.Doc
+			if doc == nil {
+				doc = n.Comment
+			}
+			for _, name := range n.Names {
+				docs[name.Pos()] = doc
+			}
+		}
+		return true
+	})
+}
+
+// packageImporter imports packages from export data,
+// and creates stub packages for import paths without export data.
+//
+// A stub package declares every identifier referenced by the files as a named struct,
+// except runtime.Object, which is an interface.
+// Only the structs listed in knownDeepCopyTypes have DeepCopyInto and DeepCopy methods.
+type packageImporter struct {
+	gc      types.Importer
+	source  types.Importer
+	exports map[string]string
+
+	refs  map[string]sets.Set[string]
+	names map[string]string
+	stubs sets.Set[string]
+	pkgs  map[string]*types.Package
+}
+
+func newPackageImporter(fset *token.FileSet, dir string, files []*ast.File, goMod []byte) *packageImporter {
+	s := &packageImporter{
+		refs:  make(map[string]sets.Set[string]),
+		names: make(map[string]string),
+		stubs: sets.New[string](),
+		pkgs:  make(map[string]*types.Package),
+	}
+	for _, f := range files {
+		local := make(map[string]string, len(f.Imports))
+		for _, imp := range f.Imports {
+			p, _ := strconv.Unquote(imp.Path.Value)
+			name := guessPackageName(p)
+			s.names[p] = name
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			local[name] = p
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			x, ok := sel.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if p, ok := local[x.Name]; ok {
+				if s.refs[p] == nil {
+					s.refs[p] = sets.New[string]()
+				}
+				s.refs[p].Insert(sel.Sel.Name)
+			}
+			return true
+		})
+	}
+	s.exports = listExports(dir, sets.KeySet(s.names), goMod)
+	s.gc = importer.ForCompiler(fset, "gc", func(p string) (io.ReadCloser, error) {
+		if e, ok := s.exports[p]; ok {
+			return os.Open(e)
+		}
+		return nil, errors.New("no export data for " + p)
+	})
+	s.source = importer.ForCompiler(fset, "source", nil)
+	return s
+}
+
+// listExports runs "go list -export" in dir and returns the export data files of the packages
+// and their dependencies.
+// Packages that are not found in dir are listed again in a temporary module with goMod.
+// It never touches go.mod of dir or the network.
+func listExports(dir string, paths sets.Set[string], goMod []byte) map[string]string {
+	exports := make(map[string]string)
+	paths.Delete("C", "unsafe")
+	if paths.Len() == 0 {
+		return exports
+	}
+	// the package directory may not be written yet; go list works in any directory of the module
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			goList(exports, dir, paths, "GOFLAGS=-mod=readonly", "GOPROXY=off")
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	// packages of the module itself cannot be found in another module
+	module := modfile.ModulePath(goMod)
+	missing := sets.New[string]()
+	for p := range paths {
+		if _, ok := exports[p]; !ok && !isStdLib(p) && p != module && !strings.HasPrefix(p, module+"/") {
+			missing.Insert(p)
+		}
+	}
+	if missing.Len() == 0 || len(module) == 0 {
+		return exports
+	}
+	tmp, err := os.MkdirTemp("", "koolbuilder-")
+	if err != nil {
+		return exports
+	}
+	defer os.RemoveAll(tmp)
+	if err = os.WriteFile(filepath.Join(tmp, "go.mod"), goMod, 0644); err != nil {
+		return exports
+	}
+	// requirements are resolved from the module cache only; go.sum is not verified against the checksum database
+	goList(exports, tmp, missing, "GOFLAGS=-mod=mod", "GOPROXY=off", "GOSUMDB=off")
+	return exports
+}
+
+// goList adds the export data files of paths and their dependencies listed in dir to exports.
+func goList(exports map[string]string, dir string, paths sets.Set[string], env ...string) {
+	cmd := exec.Command("go", append([]string{"list", "-e", "-export", "-deps", "-json=ImportPath,Export,Error", "--"}, sets.List(paths)...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return
+	}
+	dec := json.NewDecoder(&stdout)
+	for {
+		var p struct {
+			ImportPath string
+			Export     string
+			Error      *struct{ Err string }
+		}
+		if err := dec.Decode(&p); err != nil {
+			break
+		}
+		if _, ok := exports[p.ImportPath]; !ok && p.Error == nil && len(p.Export) > 0 {
+			exports[p.ImportPath] = p.Export
+		}
+	}
+}
+
+func (s *packageImporter) Import(p string) (*types.Package, error) {
+	if _, ok := s.exports[p]; ok {
+		return s.gc.Import(p)
+	}
+	if isStdLib(p) {
+		return s.source.Import(p)
+	}
+	if pkg, ok := s.pkgs[p]; ok {
+		return pkg, nil
+	}
+	pkg := types.NewPackage(p, s.names[p])
+	for _, name := range sets.List(s.refs[p]) {
+		obj := types.NewTypeName(token.NoPos, pkg, name, nil)
+		if p+"."+name == runtimeObject {
+			types.NewNamed(obj, types.NewInterfaceType(nil, nil), nil)
+			pkg.Scope().Insert(obj)
+			continue
+		}
+		named := types.NewNamed(obj, types.NewStruct(nil, nil), nil)
+		if knownDeepCopyTypes.Has(p + "." + name) {
+			ptr := types.NewPointer(named)
+			recv := types.NewVar(token.NoPos, pkg, "in", ptr)
+			out := types.NewTuple(types.NewVar(token.NoPos, pkg, "out", ptr))
+			named.AddMethod(types.NewFunc(token.NoPos, pkg, "DeepCopyInto", types.NewSignatureType(recv, nil, nil, out, nil, false)))
+			named.AddMethod(types.NewFunc(token.NoPos, pkg, "DeepCopy", types.NewSignatureType(recv, nil, nil, nil, out, false)))
+		}
+		pkg.Scope().Insert(obj)
+	}
+	pkg.MarkComplete()
+	s.pkgs[p] = pkg
+	s.stubs.Insert(p)
+	return pkg, nil
+}
+
+func isStdLib(p string) bool {
+	return !strings.Contains(strings.Split(p, "/")[0], ".")
+}
+
+// guessPackageName guesses the package name of an import path.
+//
+//	k8s.io/apimachinery/pkg/apis/meta/v1 -> v1
+//	gopkg.in/yaml.v3                     -> yaml
+//	github.com/foo/go-bar                -> bar
+func guessPackageName(p string) string {
+	name := path.Base(p)
+	if i := strings.Index(name, ".v"); i > 0 {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	return packageNameFromPath(name)
+}
@@ -0,0 +1,525 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	msgFormatNotChecked    = `format is checked by the API server only, not by Validate`
+	msgFailedToGenValidate = `failed to generate Validate`
+)
+
+const (
+	validateFileSuffix = "_gen.validate.go"
+	importField        = `"k8s.io/apimachinery/pkg/util/validation/field"`
+)
+
+// formatCheck is the go condition that reports whether the string %[1]s is invalid for a format.
+type formatCheck struct {
+	imports []string
+	invalid string
+}
+
+// formatChecks are the formats that are checked by Validate.
+// Other formats are checked by the API server only.
+var formatChecks = map[string]*formatCheck{
+	"date-time": {[]string{`"time"`}, `_, err := time.Parse(time.RFC3339, %[1]s); err != nil`},
+	"date":      {[]string{`"time"`}, `_, err := time.Parse(time.DateOnly, %[1]s); err != nil`},
+	"email":     {[]string{`"net/mail"`}, `_, err := mail.ParseAddress(%[1]s); err != nil`},
+	"uri":       {[]string{`"net/url"`}, `u, err := url.Parse(%[1]s); err != nil || !u.IsAbs()`},
+	"ipv4":      {[]string{`"net"`}, `ip := net.ParseIP(%[1]s); ip == nil || ip.To4() == nil`},
+	"ipv6":      {[]string{`"net"`}, `ip := net.ParseIP(%[1]s); ip == nil || ip.To4() != nil`},
+	"byte":      {[]string{`"encoding/base64"`}, `_, err := base64.StdEncoding.DecodeString(%[1]s); err != nil`},
+	"hostname":  {[]string{`"k8s.io/apimachinery/pkg/util/validation"`}, `len(validation.IsDNS1123Subdomain(%[1]s)) > 0`},
+}
+
+// ValidateFile is the data of template validate.
+type ValidateFile struct {
+	Package  string
+	Imports  []string
+	Kind     string
+	Patterns []ValidatePattern
+	Funcs    []ValidateFunc
+	// HasChecks decides whether Validate calls the validate function of Kind.
+	HasChecks bool
+}
+
+type ValidatePattern struct {
+	Name string
+	// Regexp is a go string literal.
+	Regexp string
+}
+
+type ValidateFunc struct {
+	Type string
+	Body string
+}
+
+// validateGen generates the validation of types from their markers.
+//
+// Generated files in the same package share one validateGen,
+// so that a validate function or pattern used by several resources is generated only once.
+type validateGen struct {
+	pkg *loadedPackage
+
+	// planned is the set of local types whose validate functions are generated
+	planned  sets.Set[string]
+	patterns map[string]string
+	needs    map[types.Type]bool
+
+	// state of the current file
+	file    *ValidateFile
+	imports sets.Set[string]
+	depth   int
+}
+
+func newValidateGen(pkg *loadedPackage) *validateGen {
+	return &validateGen{
+		pkg:      pkg,
+		planned:  sets.New[string](),
+		patterns: make(map[string]string),
+		needs:    make(map[types.Type]bool),
+	}
+}
+
+// generate returns the validate file of kind.
+func (g *validateGen) generate(kind string) (*ValidateFile, error) {
+	obj, ok := g.pkg.types.Scope().Lookup(kind).(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", msgTypeNotFound, kind)
+	}
+	named, ok := obj.Type().(*types.Named)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", msgTypeNotStruct, kind)
+	}
+	if _, ok = named.Underlying().(*types.Struct); !ok {
+		return nil, fmt.Errorf("%s: %s", msgTypeNotStruct, kind)
+	}
+	g.file = &ValidateFile{Package: g.pkg.types.Name(), Kind: kind}
+	g.imports = sets.New[string]()
+
+	needs, err := g.needsValidation(named)
+	if err != nil {
+		return nil, err
+	}
+	if needs {
+		g.file.HasChecks = true
+		g.imports.Insert(importField)
+		queue := []*types.Named{named}
+		// the validate function of kind may be planned by another resource
+		if g.planned.Has(kind) {
+			queue = nil
+		}
+		g.planned.Insert(kind)
+		for i := 0; i < len(queue); i++ {
+			var sb strings.Builder
+			deps, err := g.structBody(&sb, queue[i].Underlying().(*types.Struct), "in", "path")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", queue[i].Obj().Name(), err)
+			}
+			g.file.Funcs = append(g.file.Funcs, ValidateFunc{
+				Type: queue[i].Obj().Name(),
+				Body: strings.TrimSuffix(sb.String(), NewLine),
+			})
+			queue = append(queue, deps...)
+		}
+	}
+	g.file.Imports = sets.List(g.imports)
+	return g.file, nil
+}
+
+// needsValidation reports whether a value of t has anything to check.
+func (g *validateGen) needsValidation(t types.Type) (bool, error) {
+	if v, ok := g.needs[t]; ok {
+		return v, nil
+	}
+	// recursive types need validation only if a type in the cycle has checks
+	g.needs[t] = false
+	var v bool
+	var err error
+	if named, ok := t.(*types.Named); ok && named.Obj().Pkg() == g.pkg.types {
+		m, err := g.pkg.markersOf(named.Obj())
+		if err != nil {
+			return false, err
+		}
+		v = m.hasChecks()
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Pointer:
+		v, err = g.or(v, u.Elem())
+	case *types.Slice:
+		v, err = g.or(v, u.Elem())
+	case *types.Array:
+		v, err = g.or(v, u.Elem())
+	case *types.Map:
+		v, err = g.or(v, u.Elem())
+	case *types.Struct:
+		if named, ok := t.(*types.Named); ok && named.Obj().Pkg() != g.pkg.types {
+			// only types of the package have markers
+			break
+		}
+		for i := 0; i < u.NumFields() && !v && err == nil; i++ {
+			f := u.Field(i)
+			if _, _, skip := jsonField(f, u.Tag(i)); skip {
+				continue
+			}
+			var m Markers
+			if m, err = g.pkg.markersOf(f); err != nil {
+				break
+			}
+			v = m.hasChecks() || m.Required && checksRequired(f.Type())
+			v, err = g.or(v, f.Type())
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	g.needs[t] = v
+	return v, nil
+}
+
+func (g *validateGen) or(v bool, t types.Type) (bool, error) {
+	if v {
+		return true, nil
+	}
+	return g.needsValidation(t)
+}
+
+// checksRequired reports whether a missing value of t can be detected.
+func checksRequired(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Pointer, *types.Slice, *types.Map:
+		return true
+	case *types.Basic:
+		return u.Info()&types.IsString != 0
+	}
+	return false
+}
+
+// jsonField returns the json name of a struct field, or "" if it is inlined,
+// and whether its zero value is omitted.
+func jsonField(f *types.Var, tag string) (name string, omitEmpty, skip bool) {
+	name, opts, _ := strings.Cut(reflect.StructTag(tag).Get("json"), ",")
+	if name == "-" && len(opts) == 0 || !f.Exported() && !f.Embedded() {
+		return "", false, true
+	}
+	omitEmpty = strings.Contains(","+opts+",", ",omitempty,")
+	if f.Embedded() && len(name) == 0 || strings.Contains(","+opts+",", ",inline,") {
+		return "", omitEmpty, false
+	}
+	if len(name) == 0 {
+		name = f.Name()
+	}
+	return name, omitEmpty, false
+}
+
+// structBody writes the checks of the fields of st, and returns the local structs to plan.
+func (g *validateGen) structBody(sb *strings.Builder, st *types.Struct, in, path string) ([]*types.Named, error) {
+	var deps []*types.Named
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		name, omitEmpty, skip := jsonField(f, st.Tag(i))
+		if skip {
+			continue
+		}
+		m, err := g.pkg.markersOf(f)
+		if err != nil {
+			return nil, err
+		}
+		fieldPath := path
+		if len(name) > 0 {
+			fieldPath = path + ".Child(" + strconv.Quote(name) + ")"
+		}
+		d, err := g.check(sb, f.Type(), in+"."+f.Name(), fieldPath, m, omitEmpty)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		deps = append(deps, d...)
+	}
+	return deps, nil
+}
+
+// check writes the checks of the value in of type t, and returns the local structs to plan.
+// m are the markers of the field. If omitEmpty is true, a zero value is not sent to the API server,
+// so it is not checked either.
+func (g *validateGen) check(sb *strings.Builder, t types.Type, in, path string, m Markers, omitEmpty bool) ([]*types.Named, error) {
+	named, local := t.(*types.Named)
+	local = local && named.Obj().Pkg() == g.pkg.types
+	if local {
+		tm, err := g.pkg.markersOf(named.Obj())
+		if err != nil {
+			return nil, err
+		}
+		m = tm.merge(m)
+	}
+	needs, err := g.needsValidation(t)
+	if err != nil {
+		return nil, err
+	}
+	required := m.Required && checksRequired(t)
+	if !needs && !m.hasChecks() && !required {
+		return nil, nil
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Pointer:
+		if required {
+			fmt.Fprintf(sb, "if %s == nil {\nerrs = append(errs, field.Required(%s, \"\"))\n}\n", in, path)
+		}
+		m.Required = false
+		var inner strings.Builder
+		var deps []*types.Named
+		if elem, ok := u.Elem().(*types.Named); ok && elem.Obj().Pkg() == g.pkg.types && isStruct(elem) {
+			deps = g.callStruct(&inner, elem, in, path)
+		} else if deps, err = g.check(&inner, u.Elem(), deref(in, u.Elem()), path, m, false); err != nil {
+			return nil, err
+		}
+		if inner.Len() > 0 {
+			fmt.Fprintf(sb, "if %s != nil {\n%s}\n", in, inner.String())
+		}
+		return deps, nil
+
+	case *types.Basic:
+		if required {
+			fmt.Fprintf(sb, "if len(%s) == 0 {\nerrs = append(errs, field.Required(%s, \"\"))\n}\n", in, path)
+		}
+		checks, err := g.basicChecks(u, in, path, m)
+		if err != nil {
+			return nil, err
+		}
+		if omitEmpty && len(checks) > 0 {
+			zero := "0"
+			switch {
+			case u.Info()&types.IsString != 0:
+				zero = `""`
+			case u.Info()&types.IsBoolean != 0:
+				zero = "false"
+			}
+			fmt.Fprintf(sb, "if %s != %s {\n%s}\n", in, zero, checks)
+		} else {
+			sb.WriteString(checks)
+		}
+		return nil, nil
+
+	case *types.Slice, *types.Array:
+		var elem types.Type
+		if s, ok := u.(*types.Slice); ok {
+			elem = s.Elem()
+			if required {
+				fmt.Fprintf(sb, "if len(%s) == 0 {\nerrs = append(errs, field.Required(%s, \"\"))\n}\n", in, path)
+			}
+			if m.MaxItems != nil {
+				fmt.Fprintf(sb, "if len(%s) > %d {\nerrs = append(errs, field.TooMany(%s, len(%s), %d))\n}\n", in, *m.MaxItems, path, in, *m.MaxItems)
+			}
+		} else {
+			elem = u.(*types.Array).Elem()
+		}
+		return g.loop(sb, elem, in, path, false)
+
+	case *types.Map:
+		if required {
+			fmt.Fprintf(sb, "if len(%s) == 0 {\nerrs = append(errs, field.Required(%s, \"\"))\n}\n", in, path)
+		}
+		if b, ok := u.Key().Underlying().(*types.Basic); !ok || b.Info()&types.IsString == 0 {
+			// field.Path only has string keys
+			return nil, nil
+		}
+		return g.loop(sb, u.Elem(), in, path, true)
+
+	case *types.Struct:
+		if local && named != nil {
+			return g.callStruct(sb, named, "&"+in, path), nil
+		}
+		if named == nil {
+			return g.structBody(sb, u, in, path)
+		}
+	}
+	return nil, nil
+}
+
+// basicChecks returns the checks of the value in of basic type b.
+func (g *validateGen) basicChecks(b *types.Basic, in, path string, m Markers) (string, error) {
+	var sb strings.Builder
+	invalid := func(value, msg string) {
+		fmt.Fprintf(&sb, "errs = append(errs, field.Invalid(%s, %s, %s))\n", path, value, strconv.Quote(msg))
+	}
+	if b.Info()&types.IsNumeric != 0 {
+		if m.Minimum != nil {
+			min := strconv.FormatFloat(*m.Minimum, 'f', -1, 64)
+			fmt.Fprintf(&sb, "if float64(%s) < %s {\n", in, min)
+			invalid(in, "must be greater than or equal to "+min)
+			sb.WriteString("}\n")
+		}
+		if m.Maximum != nil {
+			max := strconv.FormatFloat(*m.Maximum, 'f', -1, 64)
+			fmt.Fprintf(&sb, "if float64(%s) > %s {\n", in, max)
+			invalid(in, "must be less than or equal to "+max)
+			sb.WriteString("}\n")
+		}
+	}
+	if b.Info()&types.IsString != 0 {
+		if len(m.Pattern) > 0 {
+			fmt.Fprintf(&sb, "if !%s.MatchString(string(%s)) {\n", g.pattern(m.Pattern), in)
+			invalid(in, "must match pattern "+m.Pattern)
+			sb.WriteString("}\n")
+		}
+		if check := formatChecks[m.Format]; check != nil {
+			g.imports.Insert(check.imports...)
+			fmt.Fprintf(&sb, "if "+check.invalid+" {\n", "string("+in+")")
+			invalid(in, "must be a valid "+m.Format)
+			sb.WriteString("}\n")
+		} else if len(m.Format) > 0 {
+			log.Warn(msgFormatNotChecked, "format", m.Format)
+		}
+	}
+	if m.Enum != nil {
+		cases, supported, err := enumCases(b, m.Enum)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "switch %s {\ncase %s:\ndefault:\nerrs = append(errs, field.NotSupported(%s, %s, []string{%s}))\n}\n",
+			in, cases, path, in, supported)
+	}
+	return sb.String(), nil
+}
+
+// deref returns the expression that dereferences the pointer in to elem.
+func deref(in string, elem types.Type) string {
+	switch elem.Underlying().(type) {
+	case *types.Slice, *types.Array, *types.Map:
+		// (*in)[i] must be parenthesized
+		return "(*" + in + ")"
+	}
+	return "*" + in
+}
+
+// loop writes a loop that checks the elements of a slice, an array or a map.
+func (g *validateGen) loop(sb *strings.Builder, elem types.Type, in, path string, isMap bool) ([]*types.Named, error) {
+	needs, err := g.needsValidation(elem)
+	if err != nil || !needs {
+		return nil, err
+	}
+	g.depth++
+	defer func() { g.depth-- }()
+	// numbered variables do not shadow the variables of outer loops
+	var inner strings.Builder
+	var deps []*types.Named
+	if isMap {
+		// map elements are not addressable, so they are checked by value
+		k, v := fmt.Sprintf("k%d", g.depth), fmt.Sprintf("v%d", g.depth)
+		if deps, err = g.check(&inner, elem, v, path+".Key(string("+k+"))", Markers{}, false); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(sb, "for %s, %s := range %s {\n%s}\n", k, v, in, inner.String())
+		return deps, nil
+	}
+	i := fmt.Sprintf("i%d", g.depth)
+	if deps, err = g.check(&inner, elem, in+"["+i+"]", path+".Index("+i+")", Markers{}, false); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(sb, "for %s := range %s {\n%s}\n", i, in, inner.String())
+	return deps, nil
+}
+
+// callStruct writes the call of the validate function of a local struct, and plans it.
+func (g *validateGen) callStruct(sb *strings.Builder, named *types.Named, in, path string) []*types.Named {
+	fmt.Fprintf(sb, "errs = append(errs, validate%s(%s, %s)...)\n", named.Obj().Name(), in, path)
+	if g.planned.Has(named.Obj().Name()) {
+		return nil
+	}
+	g.planned.Insert(named.Obj().Name())
+	return []*types.Named{named}
+}
+
+func isStruct(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Struct)
+	return ok
+}
+
+// pattern returns the name of the variable of a compiled pattern, and declares it if needed.
+// The variable is declared in the first file that uses the pattern,
+// so only that file imports regexp.
+func (g *validateGen) pattern(pattern string) string {
+	if name, ok := g.patterns[pattern]; ok {
+		return name
+	}
+	g.imports.Insert(`"regexp"`)
+	name := fmt.Sprintf("pattern%d", len(g.patterns))
+	g.patterns[pattern] = name
+	g.file.Patterns = append(g.file.Patterns, ValidatePattern{Name: name, Regexp: strconv.Quote(pattern)})
+	return name
+}
+
+// enumCases returns the case list and the supported values of an enum of basic type b.
+func enumCases(b *types.Basic, enum []any) (cases, supported string, err error) {
+	c := make([]string, 0, len(enum))
+	s := make([]string, 0, len(enum))
+	for _, e := range enum {
+		var lit string
+		switch v := e.(type) {
+		case string:
+			if b.Info()&types.IsString == 0 {
+				return "", "", fmt.Errorf("%s: enum value %q is not a %s", msgInvalidMarker, v, b.Name())
+			}
+			lit = strconv.Quote(v)
+		case float64:
+			if b.Info()&types.IsNumeric == 0 || b.Info()&types.IsInteger != 0 && v != float64(int64(v)) {
+				return "", "", fmt.Errorf("%s: enum value %v is not a %s", msgInvalidMarker, v, b.Name())
+			}
+			lit = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			if b.Info()&types.IsBoolean == 0 {
+				return "", "", fmt.Errorf("%s: enum value %v is not a %s", msgInvalidMarker, v, b.Name())
+			}
+			lit = strconv.FormatBool(v)
+		default:
+			return "", "", fmt.Errorf("%s: unsupported enum value %v", msgInvalidMarker, v)
+		}
+		c = append(c, lit)
+		s = append(s, strconv.Quote(fmt.Sprint(e)))
+	}
+	return strings.Join(c, ", "), strings.Join(s, ", "), nil
+}
+
+// CreateOrRewriteValidate generates the Validate method of each custom resource whose template is generated,
+// from the validation markers of its type.
+func CreateOrRewriteValidate(tx *Transaction, tmpl *template.Template, config *Controller) error {
+	gens := make(map[string]*validateGen)
+	for _, r := range config.generatedResources() {
+		gen, ok := gens[r.Dir]
+		if !ok {
+			pkg, err := config.resourcePackage(tx, r.Dir)
+			if err != nil {
+				log.Error("failed to load package", "package", r.Package, "directory", r.Dir, "cause", err)
+				return err
+			}
+			gen = newValidateGen(pkg)
+			gens[r.Dir] = gen
+		}
+		file, err := gen.generate(r.Kind)
+		if err != nil {
+			log.Error(msgFailedToGenValidate, "resource", r.Kind, "package", r.Package, "cause", err)
+			return errors.New(msgFailedToGenValidate)
+		}
+		sort.Strings(file.Imports)
+
+		fp := filepath.Join(r.Dir, r.LowerKind+validateFileSuffix)
+		log.Info("write validate", "resource", r.Kind, "file", fp)
+		b, err := renderGo(tmpl, file)
+		if err != nil {
+			return err
+		}
+		tx.Add(fp, b)
+	}
+	return nil
+}
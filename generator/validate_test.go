@@ -0,0 +1,96 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validateTypes are two kinds in one package, which share a pattern and a struct.
+const validateTypes = `package v1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+type Foo struct {
+	metav1.TypeMeta   ` + "`json:\",inline\"`" + `
+	metav1.ObjectMeta ` + "`json:\"metadata,omitempty\"`" + `
+
+	// +kubebuilder:validation:Pattern=` + "`^[a-z]+$`" + `
+	Name   string ` + "`json:\"name\"`" + `
+	Shared Shared ` + "`json:\"shared\"`" + `
+}
+
+type Bar struct {
+	metav1.TypeMeta   ` + "`json:\",inline\"`" + `
+	metav1.ObjectMeta ` + "`json:\"metadata,omitempty\"`" + `
+
+	// +kubebuilder:validation:Pattern=` + "`^[a-z]+$`" + `
+	Alias  string  ` + "`json:\"alias\"`" + `
+	Shared *Shared ` + "`json:\"shared,omitempty\"`" + `
+}
+
+type Shared struct {
+	// +kubebuilder:validation:Minimum=1
+	Replicas int32 ` + "`json:\"replicas\"`" + `
+}
+`
+
+const validateTest = `package v1
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	if err := (&Foo{Name: "foo", Shared: Shared{Replicas: 1}}).Validate(); err != nil {
+		t.Errorf("valid Foo: %v", err)
+	}
+	if err := (&Foo{Name: "Foo", Shared: Shared{Replicas: 1}}).Validate(); err == nil {
+		t.Error("Foo with invalid name is valid")
+	}
+	if err := (&Bar{Alias: "bar", Shared: &Shared{}}).Validate(); err == nil {
+		t.Error("Bar with invalid replicas is valid")
+	}
+	if err := (&Bar{Alias: "Bar"}).Validate(); err == nil {
+		t.Error("Bar with invalid alias is valid")
+	}
+}
+`
+
+func TestValidateTwoKindsCompile(t *testing.T) {
+	dir := newGoModule(t, map[string]string{
+		"api/v1/types.go":         validateTypes,
+		"api/v1/validate_test.go": validateTest,
+	})
+	pkgDir := filepath.Join(dir, "api", "v1")
+	pkg, err := loadPackage(pkgDir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := newValidateGen(pkg)
+	tmpl := parseTemplate(t, "validate.go.tmpl")
+	for _, kind := range []string{"Foo", "Bar"} {
+		file, err := gen.generate(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind == "Bar" {
+			// the pattern and validateShared are declared in the file of Foo
+			if len(file.Patterns) > 0 || len(file.Funcs) != 1 {
+				t.Errorf("patterns = %v, funcs = %d, want declared once", file.Patterns, len(file.Funcs))
+			}
+			for _, imp := range file.Imports {
+				if imp == `"regexp"` {
+					t.Errorf("file of Bar imports regexp without declaring a pattern")
+				}
+			}
+		}
+		b, err := renderGo(tmpl, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(pkgDir, strings.ToLower(kind)+validateFileSuffix), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGoTest(t, dir)
+}
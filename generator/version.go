@@ -0,0 +1,204 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/FlyingOnion/pkg/log"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	msgVersionsNeedTemplate       = `versions are only supported by custom resources whose template is generated`
+	msgInvalidVersionName         = `invalid version name; must be like v1, v1beta1 or v1alpha1`
+	msgDuplicateVersion           = `duplicate version`
+	msgNotOneStorageVersion       = `exactly one version must be the storage version`
+	msgNotOneHubVersion           = `at most one version can be the hub`
+	msgWatchedVersionNotFound     = `version of the resource must be one of its versions`
+	msgWatchedVersionNotServed    = `version watched by the informer must be served`
+	msgNoVersionPackage           = `package of version cannot be derived from the resource package; set package of the version`
+	msgInconsistentVersionPackage = `package of the watched version must be the package of the resource`
+	msgVersionInMainPackage       = `versions of a multi-version resource cannot be in the main package`
+	msgVersionsInSamePackage      = `versions of a resource must be in different packages`
+)
+
+// ResourceVersion is a version of a multi-version custom resource.
+//
+//	version: v1 # the version watched by the informer
+//	package: <module>/api/v1
+//	versions:
+//	- name: v1alpha1
+//	- name: v1
+//	  storage: true
+//	  hub: true
+type ResourceVersion struct {
+	Name string `yaml:"name"`
+	// Package is the go package of the version.
+	// By default the version element of the resource package is replaced, e.g. <module>/api/v1alpha1.
+	Package string `yaml:"package"`
+	// Served is true by default.
+	Served *bool `yaml:"served"`
+	// Storage is the version persisted in etcd. Exactly one version is the storage version.
+	Storage bool `yaml:"storage"`
+	// Hub is the version that other versions convert to and from. By default it is the storage version.
+	Hub bool `yaml:"hub"`
+}
+
+// initVersions checks the versions of r and initializes a resource of each version.
+// A resource without versions has one version, which is served, stored and the hub.
+//
+// It must be called after initResourcePackage.
+func (r *Resource) initVersions(base, module string) error {
+	if len(r.Versions) == 0 {
+		r.served, r.storage, r.hub = true, true, true
+		r.versions = []*Resource{r}
+		return nil
+	}
+	var storage, hub *ResourceVersion
+	names := sets.New[string]()
+	for i := range r.Versions {
+		v := &(r.Versions[i])
+		switch {
+		case !versionRegex.MatchString(v.Name):
+			return fmt.Errorf("%s: %q", msgInvalidVersionName, v.Name)
+		case names.Has(v.Name):
+			return fmt.Errorf("%s: %s", msgDuplicateVersion, v.Name)
+		case v.Storage && storage != nil:
+			return errors.New(msgNotOneStorageVersion)
+		case v.Hub && hub != nil:
+			return errors.New(msgNotOneHubVersion)
+		}
+		names.Insert(v.Name)
+		if v.Storage {
+			storage = v
+		}
+		if v.Hub {
+			hub = v
+		}
+	}
+	if storage == nil {
+		return errors.New(msgNotOneStorageVersion)
+	}
+	if hub == nil {
+		hub = storage
+	}
+	if !names.Has(r.Version) {
+		return fmt.Errorf("%s: %s", msgWatchedVersionNotFound, r.Version)
+	}
+
+	dirs := make(map[string]string, len(r.Versions))
+	r.versions = make([]*Resource, 0, len(r.Versions))
+	for i := range r.Versions {
+		v := &(r.Versions[i])
+		vr := r
+		if v.Name != r.Version {
+			vr = new(Resource)
+			*vr = *r
+			vr.Version, vr.Package, vr.HasValidate = v.Name, v.Package, false
+			if len(vr.Package) == 0 {
+				vr.Package = versionPackage(r.Package, r.Version, v.Name)
+			}
+			if len(vr.Package) == 0 {
+				return fmt.Errorf("%s: %s", msgNoVersionPackage, v.Name)
+			}
+			if err := initResourcePackage(vr, base, module); err != nil {
+				return err
+			}
+		} else if len(v.Package) > 0 && v.Package != r.Package {
+			return fmt.Errorf("%s: %s", msgInconsistentVersionPackage, v.Name)
+		}
+		if vr.GoPackage == "main" {
+			return fmt.Errorf("%s: %s", msgVersionInMainPackage, v.Name)
+		}
+		if other, ok := dirs[vr.Dir]; ok {
+			return fmt.Errorf("%s: %s, %s", msgVersionsInSamePackage, other, v.Name)
+		}
+		dirs[vr.Dir] = v.Name
+		vr.served = v.Served == nil || *v.Served
+		vr.storage, vr.hub = v == storage, v == hub
+		if vr == r && !vr.served {
+			return fmt.Errorf("%s: %s", msgWatchedVersionNotServed, v.Name)
+		}
+		r.versions = append(r.versions, vr)
+	}
+	return nil
+}
+
+// defaultWatchedVersion sets the version of a multi-version resource to its storage version if it is empty.
+func (r *Resource) defaultWatchedVersion() {
+	if len(r.Version) > 0 {
+		return
+	}
+	for _, v := range r.Versions {
+		if v.Storage {
+			r.Version = v.Name
+			return
+		}
+	}
+}
+
+// versionPackage returns the package of version by replacing the last element of pkg,
+// or "" if the last element is not the version of pkg.
+func versionPackage(pkg, pkgVersion, version string) string {
+	if path.Base(pkg) != pkgVersion {
+		return ""
+	}
+	return path.Join(path.Dir(pkg), version)
+}
+
+// hubVersion returns the hub version of r.
+func (r *Resource) hubVersion() *Resource {
+	for _, v := range r.versions {
+		if v.hub {
+			return v
+		}
+	}
+	return r
+}
+
+// generatedResources returns the custom resources whose templates are generated,
+// one for each version.
+func (c *Controller) generatedResources() []*Resource {
+	resources := make([]*Resource, 0, len(c.Resources))
+	for i := range c.Resources {
+		r := &(c.Resources[i])
+		if !r.IsCustom || r.Template == TemplateNone {
+			continue
+		}
+		resources = append(resources, r.versions...)
+	}
+	return resources
+}
+
+// checkVersionsOfPackages checks that all resources in the same package have the same group and version,
+// as register.go registers them to a single group version.
+func (c *Controller) checkVersionsOfPackages() error {
+	byDir := make(map[string]*Resource)
+	for _, r := range c.generatedResources() {
+		other, ok := byDir[r.Dir]
+		if !ok {
+			byDir[r.Dir] = r
+			continue
+		}
+		if other.SchemaGroup != r.SchemaGroup || other.Version != r.Version {
+			log.Error(msgConfigInvalid,
+				"cause", msgInconsistentGroupVersion,
+				"package", r.Package,
+				"kinds", other.Kind+"/"+other.Version+", "+r.Kind+"/"+r.Version,
+				"tip", msgInconsistentGroupVersionTip,
+			)
+			return errors.New(msgConfigInvalid)
+		}
+	}
+	return nil
+}
+
+// validateVersionsTemplate checks that only custom resources whose template is generated have versions,
+// since the other versions are generated from the template.
+func (r *Resource) validateVersionsTemplate() error {
+	if len(r.Versions) > 0 && (!r.IsCustom || r.Template == TemplateNone) {
+		return errors.New(msgVersionsNeedTemplate)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package generator
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withVersions initializes the single version of each resource, like initVersions of a resource without versions.
+func withVersions(c *Controller) *Controller {
+	for i := range c.Resources {
+		r := &(c.Resources[i])
+		r.served, r.storage, r.hub = true, true, true
+		r.versions = []*Resource{r}
+	}
+	return c
+}
+
+func TestInitVersions(t *testing.T) {
+	const module = "example.com/foo"
+	served := false
+	tests := []struct {
+		name     string
+		version  string
+		pkg      string
+		versions []ResourceVersion
+		// want are the names, packages and flags of the versions, e.g. "v1 api/v1 served storage hub"
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "single version",
+			version: "v1",
+			pkg:     module + "/api/v1",
+			want:    []string{"v1 api/v1 served storage hub"},
+		},
+		{
+			name:     "storage is the hub by default",
+			pkg:      module + "/api/v1",
+			versions: []ResourceVersion{{Name: "v1alpha1"}, {Name: "v1", Storage: true}},
+			want:     []string{"v1alpha1 api/v1alpha1 served", "v1 api/v1 served storage hub"},
+		},
+		{
+			name:     "explicit hub and package",
+			version:  "v1",
+			pkg:      module + "/api/v1",
+			versions: []ResourceVersion{{Name: "v1", Storage: true}, {Name: "v2", Package: module + "/apis/v2", Hub: true, Served: &served}},
+			want:     []string{"v1 api/v1 served storage", "v2 apis/v2 hub"},
+		},
+		{
+			name:     "no storage version",
+			version:  "v1",
+			pkg:      module + "/api/v1",
+			versions: []ResourceVersion{{Name: "v1"}, {Name: "v2"}},
+			wantErr:  true,
+		},
+		{
+			name:     "two storage versions",
+			version:  "v1",
+			pkg:      module + "/api/v1",
+			versions: []ResourceVersion{{Name: "v1", Storage: true}, {Name: "v2", Storage: true}},
+			wantErr:  true,
+		},
+		{
+			name:     "two hubs",
+			version:  "v1",
+			pkg:      module + "/api/v1",
+			versions: []ResourceVersion{{Name: "v1", Storage: true, Hub: true}, {Name: "v2", Hub: true}},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid version name",
+			version:  "v1",
+			pkg:      module + "/api/v1",
+			versions: []ResourceVersion{{Name: "v1", Storage: true}, {Name: "version2"}},
+			wantErr:  true,
+		},
+		{
+			name:     "duplicate version",
+			version:  "v1",
+			pkg:      module + "/api/v1",
+			versions: []ResourceVersion{{Name: "v1", Storage: true}, {Name: "v1"}},
+			wantErr:  true,
+		},
+		{
+			name:     "watched version not found",
+			version:  "v3",
+			pkg:      module + "/api/v3",
+			versions: []ResourceVersion{{Name: "v1", Storage: true}, {Name: "v2"}},
+			wantErr:  true,
+		},
+		{
+			name:     "watched version not served",
+			version:  "v2",
+			pkg:      module + "/api/v2",
+			versions: []ResourceVersion{{Name: "v1", Storage: true}, {Name: "v2", Served: &served}},
+			wantErr:  true,
+		},
+		{
+			name:     "package cannot be derived",
+			version:  "v1",
+			pkg:      module + "/api",
+			versions: []ResourceVersion{{Name: "v1", Storage: true}, {Name: "v2"}},
+			wantErr:  true,
+		},
+		{
+			name:     "inconsistent package of the watched version",
+			version:  "v1",
+			pkg:      module + "/api/v1",
+			versions: []ResourceVersion{{Name: "v1", Package: module + "/apis/v1", Storage: true}},
+			wantErr:  true,
+		},
+		{
+			name:     "versions in the same package",
+			version:  "v1",
+			pkg:      module + "/api/v1",
+			versions: []ResourceVersion{{Name: "v1", Storage: true}, {Name: "v2", Package: module + "/api/v1"}},
+			wantErr:  true,
+		},
+		{
+			name:     "version in the main package",
+			version:  "v1",
+			pkg:      module,
+			versions: []ResourceVersion{{Name: "v1", Storage: true}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			r := &Resource{Kind: "Foo", IsCustom: true, Template: TemplateBoth, Version: tt.version, Package: tt.pkg, Versions: tt.versions}
+			r.defaultWatchedVersion()
+			if err := initResourcePackage(r, base, module); err != nil {
+				t.Fatal(err)
+			}
+			err := r.initVersions(base, module)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initVersions() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := make([]string, 0, len(r.versions))
+			for _, v := range r.versions {
+				rel, err := filepath.Rel(base, v.Dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				s := v.Version + " " + filepath.ToSlash(rel)
+				for _, flag := range []struct {
+					name string
+					set  bool
+				}{{"served", v.served}, {"storage", v.storage}, {"hub", v.hub}} {
+					if flag.set {
+						s += " " + flag.name
+					}
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("versions = %q, want %q", got, tt.want)
+			}
+			found := false
+			for _, v := range r.versions {
+				found = found || v == r
+			}
+			if !found {
+				t.Error("the watched version is not the resource itself")
+			}
+		})
+	}
+}
+
+func TestVersionPackage(t *testing.T) {
+	tests := []struct {
+		pkg, pkgVersion, version string
+		want                     string
+	}{
+		{pkg: "example.com/foo/api/v1", pkgVersion: "v1", version: "v1alpha1", want: "example.com/foo/api/v1alpha1"},
+		{pkg: "example.com/foo/api", pkgVersion: "v1", version: "v2", want: ""},
+	}
+	for _, tt := range tests {
+		if got := versionPackage(tt.pkg, tt.pkgVersion, tt.version); got != tt.want {
+			t.Errorf("versionPackage(%q, %q, %q) = %q, want %q", tt.pkg, tt.pkgVersion, tt.version, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/FlyingOnion/pkg/log"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	msgNoMultiVersionResources = `conversion webhook is ignored since no custom resource has more than one version`
+	msgInvalidWebhookPort      = `conversionWebhook.port must be between 1 and 65535`
+)
+
+const (
+	webhookFileName    = "conversion_webhook.go"
+	webhookServiceFile = "config/webhook/service.yaml"
+	webhookPath        = "/convert"
+
+	defaultWebhookPort = 9443
+)
+
+// ConversionWebhook is the conversion webhook server of multi-version custom resources.
+//
+//	conversionWebhook:
+//	  enabled: true
+//	  port: 9443
+//	  service:
+//	    name: controller-webhook
+//	    namespace: default
+type ConversionWebhook struct {
+	Enabled bool `yaml:"enabled"`
+	// Port is the port the server listens on. By default it is 9443.
+	Port int `yaml:"port"`
+	// Service is the Service in front of the server, which the CRDs call.
+	Service WebhookService `yaml:"service"`
+}
+
+type WebhookService struct {
+	// Name is "<lowercase controller name>-webhook" by default.
+	Name string `yaml:"name"`
+	// Namespace is the namespace of the controller, or "default".
+	Namespace string `yaml:"namespace"`
+}
+
+// WebhookFile is the data of template conversion_webhook.
+type WebhookFile struct {
+	Imports    []string
+	Converters []WebhookConverter
+}
+
+// WebhookConverter converts a multi-version custom resource through its hub version.
+type WebhookConverter struct {
+	Group    string
+	Kind     string
+	Hub      WebhookVersion
+	Versions []WebhookVersion
+}
+
+type WebhookVersion struct {
+	Version string
+	GoType  string
+}
+
+// initConversionWebhook checks and defaults the conversion webhook.
+// It must be called after the versions of resources are initialized.
+func (c *Controller) initConversionWebhook() error {
+	if !c.ConversionWebhook.Enabled {
+		return nil
+	}
+	if len(c.multiVersionResources()) == 0 {
+		log.Warn(msgNoMultiVersionResources)
+		c.ConversionWebhook.Enabled = false
+		return nil
+	}
+	if c.ConversionWebhook.Port == 0 {
+		c.ConversionWebhook.Port = defaultWebhookPort
+	}
+	if c.ConversionWebhook.Port < 0 || c.ConversionWebhook.Port > 65535 {
+		log.Error(msgConfigInvalid, "cause", msgInvalidWebhookPort)
+		return errors.New(msgConfigInvalid)
+	}
+	if len(c.ConversionWebhook.Service.Name) == 0 {
+		c.ConversionWebhook.Service.Name = strings.ToLower(c.Name) + "-webhook"
+	}
+	if len(c.ConversionWebhook.Service.Namespace) == 0 {
+		c.ConversionWebhook.Service.Namespace = c.Namespace
+	}
+	if len(c.ConversionWebhook.Service.Namespace) == 0 {
+		c.ConversionWebhook.Service.Namespace = "default"
+	}
+	c.MainImports = append(c.MainImports, `"errors"`, `"fmt"`, `"net/http"`)
+	return nil
+}
+
+// multiVersionResources returns the custom resources with more than one version.
+func (c *Controller) multiVersionResources() []*Resource {
+	var resources []*Resource
+	for i := range c.Resources {
+		if len(c.Resources[i].versions) > 1 {
+			resources = append(resources, &(c.Resources[i]))
+		}
+	}
+	return resources
+}
+
+// crdConversion returns the conversion of the CRD of r, or nil if r is not converted by the webhook.
+func (c *Controller) crdConversion(r *Resource) *CRDConversion {
+	if !c.ConversionWebhook.Enabled || len(r.versions) < 2 {
+		return nil
+	}
+	return &CRDConversion{
+		Strategy: "Webhook",
+		Webhook: &CRDWebhookConversion{
+			ClientConfig: CRDWebhookClientConfig{
+				Service: CRDServiceReference{
+					Name:      c.ConversionWebhook.Service.Name,
+					Namespace: c.ConversionWebhook.Service.Namespace,
+					Path:      webhookPath,
+					Port:      443,
+				},
+			},
+			ConversionReviewVersions: []string{"v1"},
+		},
+	}
+}
+
+// CreateOrRewriteWebhook generates the conversion webhook server of multi-version custom resources
+// and the Service in front of it. They are removed if the webhook is disabled.
+func CreateOrRewriteWebhook(tx *Transaction, tmpl *template.Template, config *Controller) error {
+	fp := filepath.Join(config.Base, webhookFileName)
+	svc := filepath.Join(config.Base, webhookServiceFile)
+	if !config.ConversionWebhook.Enabled {
+		for _, p := range []string{fp, svc} {
+			if isGeneratedByKoolbuilder(p) {
+				log.Info("conversion webhook is disabled; remove file", "file", p)
+				tx.Remove(p)
+			}
+		}
+		return nil
+	}
+
+	file := &WebhookFile{}
+	imports := sets.New[string]()
+	goType := func(r *Resource) string {
+		alias := getAlias(r.Package)
+		imports.Insert(alias + ` "` + r.Package + `"`)
+		return alias + "." + r.Kind
+	}
+	for _, r := range config.multiVersionResources() {
+		hub := r.hubVersion()
+		conv := WebhookConverter{
+			Group: r.SchemaGroup,
+			Kind:  r.Kind,
+			Hub:   WebhookVersion{Version: hub.Version, GoType: goType(hub)},
+		}
+		for _, v := range r.versions {
+			if v != hub {
+				conv.Versions = append(conv.Versions, WebhookVersion{Version: v.Version, GoType: goType(v)})
+			}
+		}
+		file.Converters = append(file.Converters, conv)
+	}
+	file.Imports = sets.List(imports)
+
+	log.Info("write conversion webhook", "file", fp, "service", config.ConversionWebhook.Service.Namespace+"/"+config.ConversionWebhook.Service.Name)
+	b, err := renderGo(tmpl, file)
+	if err != nil {
+		return err
+	}
+	tx.Add(fp, b)
+
+	var buf bytes.Buffer
+	buf.WriteString(crdHeader)
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(newWebhookService(config)); err != nil {
+		return err
+	}
+	tx.Add(svc, buf.Bytes())
+	return nil
+}
+
+// isGeneratedByKoolbuilder reports whether the file at path exists and has the generated header.
+func isGeneratedByKoolbuilder(path string) bool {
+	b, err := os.ReadFile(path)
+	return err == nil && (bytes.HasPrefix(b, []byte(generatedHeader)) || bytes.HasPrefix(b, []byte(crdHeader)))
+}
+
+// Service is a v1 Service. Only the fields generated by koolbuilder are declared.
+type Service struct {
+	APIVersion string          `yaml:"apiVersion"`
+	Kind       string          `yaml:"kind"`
+	Metadata   ServiceMetadata `yaml:"metadata"`
+	Spec       ServiceSpec     `yaml:"spec"`
+}
+
+type ServiceMetadata struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+type ServiceSpec struct {
+	Ports    []ServicePort     `yaml:"ports"`
+	Selector map[string]string `yaml:"selector"`
+}
+
+type ServicePort struct {
+	Name       string `yaml:"name"`
+	Port       int    `yaml:"port"`
+	TargetPort int    `yaml:"targetPort"`
+}
+
+// newWebhookService returns the Service of the conversion webhook,
+// which selects the pods of the controller by label "app: <lowercase controller name>".
+func newWebhookService(config *Controller) *Service {
+	return &Service{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Metadata: ServiceMetadata{
+			Name:      config.ConversionWebhook.Service.Name,
+			Namespace: config.ConversionWebhook.Service.Namespace,
+		},
+		Spec: ServiceSpec{
+			Ports:    []ServicePort{{Name: "webhook", Port: 443, TargetPort: config.ConversionWebhook.Port}},
+			Selector: map[string]string{"app": strings.ToLower(config.Name)},
+		},
+	}
+}
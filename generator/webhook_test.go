@@ -0,0 +1,288 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// webhookTypes returns the go file of Foo in package pkg, with more imports and declarations.
+func webhookTypes(pkg string, imports []string, decls string) string {
+	return "package " + pkg + `
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	` + strings.Join(imports, "\n\t") + `
+)
+
+type Foo struct {
+	metav1.TypeMeta   ` + "`json:\",inline\"`" + `
+	metav1.ObjectMeta ` + "`json:\"metadata,omitempty\"`" + `
+
+	Spec FooSpec ` + "`json:\"spec\"`" + `
+}
+
+func (in *Foo) DeepCopyObject() runtime.Object {
+	out := *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return &out
+}
+` + decls
+}
+
+// webhookTypesV1 names the replicas "size"; it is converted through the hub version v2.
+const webhookTypesV1 = `
+type FooSpec struct {
+	Size int32 ` + "`json:\"size\"`" + `
+}
+
+func (src *Foo) ConvertTo(dst *v2.Foo) error {
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	dst.Spec.Replicas = src.Spec.Size
+	return nil
+}
+
+func (dst *Foo) ConvertFrom(src *v2.Foo) error {
+	if src.Spec.Replicas < 0 {
+		return errors.New("replicas must not be negative")
+	}
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	dst.Spec.Size = src.Spec.Replicas
+	return nil
+}
+`
+
+// webhookTypesV2 is the hub version.
+const webhookTypesV2 = `
+type FooSpec struct {
+	Replicas int32 ` + "`json:\"replicas\"`" + `
+}
+`
+
+// webhookTest sends ConversionReviews to the generated server.
+const webhookTest = `package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeConversion(t *testing.T) {
+	server := httptest.NewServer(newConversionMux())
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		desired string
+		objects []string
+		// want are the converted objects, or nil if the conversion fails
+		want []string
+	}{
+		{
+			name:    "spoke to hub",
+			desired: "example.com/v2",
+			objects: []string{` + "`" + `{"apiVersion":"example.com/v1","kind":"Foo","metadata":{"name":"a"},"spec":{"size":3}}` + "`" + `},
+			want:    []string{` + "`" + `{"kind":"Foo","apiVersion":"example.com/v2","metadata":{"name":"a","creationTimestamp":null},"spec":{"replicas":3}}` + "`" + `},
+		},
+		{
+			name:    "hub to spoke",
+			desired: "example.com/v1",
+			objects: []string{` + "`" + `{"apiVersion":"example.com/v2","kind":"Foo","metadata":{"name":"a"},"spec":{"replicas":2}}` + "`" + `},
+			want:    []string{` + "`" + `{"kind":"Foo","apiVersion":"example.com/v1","metadata":{"name":"a","creationTimestamp":null},"spec":{"size":2}}` + "`" + `},
+		},
+		{
+			name:    "same version",
+			desired: "example.com/v1",
+			objects: []string{` + "`" + `{"apiVersion":"example.com/v1","kind":"Foo","metadata":{"name":"a"},"spec":{"size":1}}` + "`" + `},
+			want:    []string{` + "`" + `{"kind":"Foo","apiVersion":"example.com/v1","metadata":{"name":"a","creationTimestamp":null},"spec":{"size":1}}` + "`" + `},
+		},
+		{
+			name:    "several objects",
+			desired: "example.com/v2",
+			objects: []string{
+				` + "`" + `{"apiVersion":"example.com/v1","kind":"Foo","metadata":{"name":"a"},"spec":{"size":1}}` + "`" + `,
+				` + "`" + `{"apiVersion":"example.com/v2","kind":"Foo","metadata":{"name":"b"},"spec":{"replicas":2}}` + "`" + `,
+			},
+			want: []string{
+				` + "`" + `{"kind":"Foo","apiVersion":"example.com/v2","metadata":{"name":"a","creationTimestamp":null},"spec":{"replicas":1}}` + "`" + `,
+				` + "`" + `{"kind":"Foo","apiVersion":"example.com/v2","metadata":{"name":"b","creationTimestamp":null},"spec":{"replicas":2}}` + "`" + `,
+			},
+		},
+		{
+			name:    "conversion error fails all objects",
+			desired: "example.com/v1",
+			objects: []string{
+				` + "`" + `{"apiVersion":"example.com/v2","kind":"Foo","metadata":{"name":"a"},"spec":{"replicas":1}}` + "`" + `,
+				` + "`" + `{"apiVersion":"example.com/v2","kind":"Foo","metadata":{"name":"b"},"spec":{"replicas":-1}}` + "`" + `,
+			},
+		},
+		{
+			name:    "unsupported kind",
+			desired: "example.com/v2",
+			objects: []string{` + "`" + `{"apiVersion":"example.com/v1","kind":"Bar"}` + "`" + `},
+		},
+		{
+			name:    "other group",
+			desired: "other.com/v2",
+			objects: []string{` + "`" + `{"apiVersion":"example.com/v1","kind":"Foo"}` + "`" + `},
+		},
+		{
+			name:    "unsupported version",
+			desired: "example.com/v3",
+			objects: []string{` + "`" + `{"apiVersion":"example.com/v1","kind":"Foo"}` + "`" + `},
+		},
+		{
+			name:    "invalid desired version",
+			desired: "example.com/v1/x",
+			objects: []string{` + "`" + `{"apiVersion":"example.com/v1","kind":"Foo"}` + "`" + `},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := ` + "`" + `{"apiVersion":"apiextensions.k8s.io/v1","kind":"ConversionReview","request":{"uid":"123","desiredAPIVersion":"` + "`" + ` +
+				tt.desired + ` + "`" + `","objects":[` + "`" + ` + strings.Join(tt.objects, ",") + ` + "`" + `]}}` + "`" + `
+			resp, err := http.Post(server.URL+"/convert", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d", resp.StatusCode)
+			}
+			var review conversionReview
+			if err = json.NewDecoder(resp.Body).Decode(&review); err != nil {
+				t.Fatal(err)
+			}
+			if review.Request != nil || review.Response == nil {
+				t.Fatalf("invalid review: %+v", review)
+			}
+			if review.Kind != "ConversionReview" || review.APIVersion != "apiextensions.k8s.io/v1" {
+				t.Errorf("type = %+v", review.TypeMeta)
+			}
+			if review.Response.UID != "123" {
+				t.Errorf("uid = %q", review.Response.UID)
+			}
+			if tt.want == nil {
+				if review.Response.Result.Status != "Failure" || len(review.Response.Result.Message) == 0 || review.Response.ConvertedObjects != nil {
+					t.Errorf("expected a failure: %+v", review.Response)
+				}
+				return
+			}
+			if review.Response.Result.Status != "Success" {
+				t.Fatalf("result = %+v", review.Response.Result)
+			}
+			if len(review.Response.ConvertedObjects) != len(tt.want) {
+				t.Fatalf("got %d objects, want %d", len(review.Response.ConvertedObjects), len(tt.want))
+			}
+			for i, obj := range review.Response.ConvertedObjects {
+				if string(obj.Raw) != tt.want[i] {
+					t.Errorf("object %d = %s, want %s", i, obj.Raw, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestServeConversionInvalidRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{name: "not POST", method: http.MethodGet, want: http.StatusMethodNotAllowed},
+		{name: "not JSON", method: http.MethodPost, body: "conversion", want: http.StatusBadRequest},
+		{name: "no request", method: http.MethodPost, body: ` + "`" + `{"kind":"ConversionReview"}` + "`" + `, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newConversionMux().ServeHTTP(rec, httptest.NewRequest(tt.method, "/convert", strings.NewReader(tt.body)))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+`
+
+func TestConversionWebhook(t *testing.T) {
+	v1, v2 := "example.com/generated/api/v1", "example.com/generated/api/v2"
+	dir := newGoModule(t, map[string]string{
+		"main.go":         "package main\n\nfunc main() {}\n",
+		"webhook_test.go": webhookTest,
+		"api/v1/types.go": webhookTypes("v1", []string{`"errors"`, `"` + v2 + `"`}, webhookTypesV1),
+		"api/v2/types.go": webhookTypes("v2", nil, webhookTypesV2),
+	})
+	file := &WebhookFile{
+		Imports: []string{getAlias(v1) + ` "` + v1 + `"`, getAlias(v2) + ` "` + v2 + `"`},
+		Converters: []WebhookConverter{{
+			Group:    "example.com",
+			Kind:     "Foo",
+			Hub:      WebhookVersion{Version: "v2", GoType: getAlias(v2) + ".Foo"},
+			Versions: []WebhookVersion{{Version: "v1", GoType: getAlias(v1) + ".Foo"}},
+		}},
+	}
+	b, err := renderGo(parseTemplate(t, "conversion_webhook.go.tmpl"), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, webhookFileName), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGoTest(t, dir)
+}
+
+func TestInitConversionWebhook(t *testing.T) {
+	multiVersion := func() []Resource {
+		r := Resource{Kind: "Foo", IsCustom: true, Template: TemplateBoth}
+		r.versions = []*Resource{&r, {Kind: "Foo", IsCustom: true, Template: TemplateBoth}}
+		return []Resource{r}
+	}
+	tests := []struct {
+		name    string
+		c       Controller
+		want    ConversionWebhook
+		wantErr bool
+	}{
+		{
+			name: "disabled",
+			c:    Controller{Resources: multiVersion()},
+		},
+		{
+			name: "no multi-version resources",
+			c:    *withVersions(&Controller{ConversionWebhook: ConversionWebhook{Enabled: true}, Resources: []Resource{{Kind: "Foo", IsCustom: true, Template: TemplateBoth}}}),
+		},
+		{
+			name: "defaults",
+			c:    Controller{Name: "Foo", Namespace: "foo", ConversionWebhook: ConversionWebhook{Enabled: true}, Resources: multiVersion()},
+			want: ConversionWebhook{Enabled: true, Port: defaultWebhookPort, Service: WebhookService{Name: "foo-webhook", Namespace: "foo"}},
+		},
+		{
+			name: "default namespace",
+			c:    Controller{Name: "Foo", ConversionWebhook: ConversionWebhook{Enabled: true, Port: 8443}, Resources: multiVersion()},
+			want: ConversionWebhook{Enabled: true, Port: 8443, Service: WebhookService{Name: "foo-webhook", Namespace: "default"}},
+		},
+		{
+			name:    "invalid port",
+			c:       Controller{ConversionWebhook: ConversionWebhook{Enabled: true, Port: 65536}, Resources: multiVersion()},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.c.initConversionWebhook()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initConversionWebhook() = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tt.c.ConversionWebhook != tt.want {
+				t.Errorf("conversionWebhook = %+v, want %+v", tt.c.ConversionWebhook, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package generator
+
+import (
+	"errors"
+
+	"github.com/FlyingOnion/pkg/log"
+)
+
+const msgInvalidWorkers = `workers must be positive`
+
+// initWorkers checks the number of workers, which is the default value of flag "--workers" in main.go.
+func (c *Controller) initWorkers() error {
+	if c.Workers < 1 {
+		log.Error(msgConfigInvalid, "cause", msgInvalidWorkers, "workers", c.Workers)
+		return errors.New(msgConfigInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitWorkers(t *testing.T) {
+	for workers, wantErr := range map[int]bool{-1: true, 0: true, 1: false, 8: false} {
+		c := &Controller{Workers: workers}
+		if err := c.initWorkers(); (err != nil) != wantErr {
+			t.Errorf("initWorkers() of %d workers error = %v, want error %v", workers, err, wantErr)
+		}
+	}
+}
+
+func TestDefaultWorkers(t *testing.T) {
+	c, err := ReadConfigFromReader(strings.NewReader("name: Foo\nresources:\n- kind: Pod\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Workers != 1 {
+		t.Errorf("workers = %d, want 1", c.Workers)
+	}
+}
+
+// workersConfig syncs pods with 4 workers.
+const workersConfig = `name: Foo
+go:
+  module: example.com/generated
+workers: 4
+resources:
+- kind: Pod
+  isNamespaced: true
+`
+
+func TestGeneratedWorkers(t *testing.T) {
+	dir := generateController(t, workersConfig, nil)
+	b, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`pflag.CommandLine.IntVar(&workers, "workers", 4, "number of workers that sync pods concurrently")`,
+		"go controller.Run(ctx, workers)",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("missing %q in main.go", want)
+		}
+	}
+	runGoTest(t, dir)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 
 	"github.com/FlyingOnion/koolbuilder/generator"
 	"github.com/FlyingOnion/pkg/log"
@@ -21,25 +22,80 @@ func mustHaveNoError(err error) {
 	}
 }
 
+func usage() {
+	log.Info("usage: koolbuilder -f config.yaml [--offline]")
+	log.Info("usage: koolbuilder undo [-f config.yaml]")
+	log.Info("usage: koolbuilder history [-f config.yaml]")
+	pflag.PrintDefaults()
+}
+
+// baseDir returns the base directory in config file,
+// or current directory if config file is not given.
+func baseDir(configFile string) string {
+	if len(configFile) == 0 {
+		return "."
+	}
+	config := mustGetOrFatal(generator.ReadConfig(configFile))
+	if len(config.Base) == 0 {
+		return "."
+	}
+	return filepath.Clean(config.Base)
+}
+
 func main() {
 	var configFile string
+	var offline bool
 	pflag.StringVarP(&configFile, "filename", "f", "", "configuration file of the operator")
+	pflag.BoolVar(&offline, "offline", false, "skip post-generation steps that require network access")
 	pflag.Parse()
 
+	switch pflag.Arg(0) {
+	case "":
+	case "undo":
+		mustHaveNoError(generator.Undo(baseDir(configFile)))
+		log.Info("all done")
+		return
+	case "history":
+		mustHaveNoError(generator.PrintHistory(os.Stdout, baseDir(configFile)))
+		return
+	default:
+		log.Error("unknown command", "command", pflag.Arg(0))
+		usage()
+		os.Exit(1)
+	}
+
 	if len(configFile) == 0 {
 		log.Error("missing configuration file")
-		log.Info("usage: koolbuilder -f config.yaml")
-		pflag.PrintDefaults()
+		usage()
 		os.Exit(1)
 	}
 
 	config := mustGetOrFatal(generator.ReadConfig(configFile))
 	mustHaveNoError(config.InitAndValidate())
-	mustHaveNoError(generator.CreateOrRewriteGoMod(tmplGoMod, config))
-	mustHaveNoError(generator.CreateOrRewrite(tmplMain, config))
-	mustHaveNoError(generator.CreateOrRewrite(tmplController, config))
-	mustHaveNoError(generator.CreateOrUpdateCustom(tmplEventHandler, config))
-	mustHaveNoError(generator.CreateOrRewriteDeepCopy(tmplDeepCopy, config))
-	mustHaveNoError(generator.RunGoModTidy(config))
+
+	// render everything first, then write all files at once
+	tx := generator.NewTransaction()
+	mustHaveNoError(generator.CreateOrRewriteGoMod(tx, tmplGoMod, config))
+	mustHaveNoError(generator.CreateOrRewrite(tx, tmplMain, config))
+	mustHaveNoError(generator.CreateOrRewrite(tx, tmplController, config))
+	mustHaveNoError(generator.CreateOrRewriteClients(tx, tmplClients, config))
+	mustHaveNoError(generator.CreateOrRewriteMetadataInformer(tx, tmplMetadataInformer, config))
+	mustHaveNoError(generator.CreateOrUpdateCustom(tx, tmplEventHandler, config))
+	// definitions go before register and deepcopy, which load them from the transaction
+	mustHaveNoError(generator.CreateOrUpdateDefinition(tx, tmplTypes, config))
+	mustHaveNoError(generator.CreateConversion(tx, tmplConversion, config))
+	mustHaveNoError(generator.CreateOrRewriteRegister(tx, tmplRegister, config))
+	mustHaveNoError(generator.CreateOrRewriteDeepCopy(tx, tmplDeepCopy, config))
+	mustHaveNoError(generator.CreateOrRewriteValidate(tx, tmplValidate, config))
+	mustHaveNoError(generator.CreateOrRewriteCRD(tx, config))
+	mustHaveNoError(generator.CreateOrUpdateSample(tx, config))
+	mustHaveNoError(generator.CreateOrRewriteWebhook(tx, tmplWebhook, config))
+	// go.mod and go.sum may be changed by post-generation steps even if they are not rendered
+	tx.Track(filepath.Join(config.Base, "go.mod"))
+	tx.Track(filepath.Join(config.Base, "go.sum"))
+	// back up the files before anything is overwritten
+	mustHaveNoError(generator.SaveSnapshot(tx, config))
+	mustHaveNoError(tx.Commit())
+	mustHaveNoError(generator.RunPostGenerate(tx, config, offline))
 	log.Info("all done")
 }